@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/nicklasfrahm/k3se/pkg/ops"
+)
+
+var testSSHCmd = &cobra.Command{
+	Use:   "test-ssh [config]",
+	Short: "Test SSH connectivity of all nodes",
+	Long: `Test the SSH connectivity of all nodes without
+installing anything. This prints the result and the
+latency for every node and fails if any node cannot
+be reached.
+
+By default the command expects a "k3se.yml" config
+file in the current directory. You may override this
+by passing a path to the configuration file as a CLI
+argument.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := log.Output(zerolog.ConsoleWriter{
+			Out:        os.Stderr,
+			TimeFormat: time.RFC3339,
+		})
+
+		opts := []ops.Option{
+			ops.WithLogger(&logger),
+		}
+
+		// Use manual override for config path if provided.
+		if len(args) == 1 {
+			opts = append(opts, ops.WithConfigPath(args[0]))
+		}
+
+		return ops.TestSSH(opts...)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(testSSHCmd)
+}
@@ -13,6 +13,8 @@ import (
 
 var kubeConfigPath string
 var skipInstall bool
+var maxLoad float64
+var maxMemoryPressure float64
 
 var upCmd = &cobra.Command{
 	Use:   "up [config]",
@@ -38,6 +40,8 @@ the new context to be written to.`,
 
 		opts := []ops.Option{
 			ops.WithLogger(&logger),
+			ops.WithMaxLoad(maxLoad),
+			ops.WithMaxMemoryPressure(maxMemoryPressure),
 		}
 
 		// Use manual override for config path if provided.
@@ -63,6 +67,8 @@ the new context to be written to.`,
 func init() {
 	upCmd.Flags().StringVarP(&kubeConfigPath, "kubeconfig", "k", "~/.kube/config", "location to write the kubeconfig")
 	upCmd.Flags().BoolVarP(&skipInstall, "skip-install", "s", false, "only download the kubeconfig")
+	upCmd.Flags().Float64Var(&maxLoad, "max-load", 0, "wait for the load average of nodes to drop below this value before installing")
+	upCmd.Flags().Float64Var(&maxMemoryPressure, "max-memory-pressure", 0, "wait for the ratio of used memory of nodes to drop below this value before installing")
 
 	rootCmd.AddCommand(upCmd)
 }
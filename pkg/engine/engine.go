@@ -2,6 +2,7 @@ package engine
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,6 +11,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"dario.cat/mergo"
 	"github.com/rs/zerolog"
@@ -21,6 +23,9 @@ import (
 
 const (
 	InstallerURL = "https://get.k3s.io"
+
+	// preflightTimeout is the maximum time to wait for a node to settle.
+	preflightTimeout = time.Minute * 5
 )
 
 // Engine is a type that encapsulates parts of the installation logic.
@@ -32,6 +37,9 @@ type Engine struct {
 	clusterToken   string
 	serverURL      string
 	cleanupPending bool
+	maxLoad        float64
+	maxMemPressure float64
+	maxCPUUsage    float64
 
 	Spec *Config
 }
@@ -44,7 +52,10 @@ func New(options ...Option) (*Engine, error) {
 	}
 
 	return &Engine{
-		Logger: opts.Logger,
+		Logger:         opts.Logger,
+		maxLoad:        opts.MaxLoad,
+		maxMemPressure: opts.MaxMemoryPressure,
+		maxCPUUsage:    opts.MaxCPUUsage,
 	}, nil
 }
 
@@ -69,6 +80,17 @@ func (e *Engine) FilterNodes(selector Role) []*Node {
 	return nodes
 }
 
+// firstServer returns the first control-plane node, which is used to run
+// cluster-wide operations, or an error if there is no control-plane node.
+func (e *Engine) firstServer() (*Node, error) {
+	servers := e.FilterNodes(RoleServer)
+	if len(servers) == 0 {
+		return nil, errors.New("no control-plane nodes specified")
+	}
+
+	return servers[0], nil
+}
+
 // SetSpec configures the desired state of the cluster. Note
 // that the config will only be applied if the verification
 // succeeds.
@@ -105,6 +127,10 @@ func (e *Engine) ConfigureNode(node *Node) error {
 
 	node.Logger.Info().Msg("Configuring node")
 
+	if err := e.preflight(node); err != nil {
+		return err
+	}
+
 	installer, err := e.fetchInstallationScript()
 	if err != nil {
 		return err
@@ -172,6 +198,24 @@ func (e *Engine) ConfigureNode(node *Node) error {
 	return nil
 }
 
+// preflight waits for the node to settle before k3s is installed,
+// which prevents installations on hosts that are busy or low on memory.
+func (e *Engine) preflight(node *Node) error {
+	if e.maxLoad > 0 {
+		if err := node.Client.WaitForLowLoad(e.maxLoad, preflightTimeout); err != nil {
+			return err
+		}
+	}
+
+	if e.maxMemPressure > 0 {
+		if err := node.Client.WaitForLowMemoryPressure(e.maxMemPressure, preflightTimeout); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // Install runs the installation script on the node.
 func (e *Engine) Install() error {
 	e.Logger.Info().Str("server_url", e.serverURL).Msg("Detecting server URL")
@@ -208,15 +252,19 @@ func (e *Engine) Uninstall() error {
 	return nil
 }
 
+// PingResult describes the outcome of an SSH connectivity test of a node.
+type PingResult struct {
+	Host    string
+	User    string
+	Latency time.Duration
+	Err     error
+}
+
 // Connect establishes an SSH connection to all nodes.
 func (e *Engine) Connect() error {
-	// Establish connection to proxy if host is specified.
-	var sshProxy *sshx.Client
-	if e.Spec.SSHProxy.Host != "" {
-		var err error
-		if sshProxy, err = sshx.NewClient(&e.Spec.SSHProxy); err != nil {
-			return err
-		}
+	sshProxy, err := e.connectProxy()
+	if err != nil {
+		return err
 	}
 
 	// Get a list of all nodes and connect to them.
@@ -236,6 +284,48 @@ func (e *Engine) Connect() error {
 	return nil
 }
 
+// Ping tests the SSH connectivity of all nodes. Unlike Connect, it does
+// not stop at the first failure, but returns the result for every node.
+func (e *Engine) Ping() ([]PingResult, error) {
+	sshProxy, err := e.connectProxy()
+	if err != nil {
+		return nil, err
+	}
+	if sshProxy != nil {
+		defer sshProxy.Close()
+	}
+
+	var results []PingResult
+	for _, node := range e.FilterNodes(RoleAny) {
+		// Inject logger into node.
+		node.Logger = e.Logger.With().Str("host", node.SSH.Host).Logger()
+
+		result := PingResult{
+			Host: node.SSH.Host,
+		}
+
+		if result.Err = node.Connect(WithSSHProxy(sshProxy), WithLogger(&node.Logger)); result.Err == nil {
+			result.Latency, result.Err = node.Client.Ping()
+			node.Disconnect()
+		}
+
+		// The user is only known after the defaults have been applied.
+		result.User = node.SSH.User
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// connectProxy establishes a connection to the SSH proxy if a host is specified.
+func (e *Engine) connectProxy() (*sshx.Client, error) {
+	if e.Spec.SSHProxy.Host == "" {
+		return nil, nil
+	}
+
+	return sshx.NewClient(&e.Spec.SSHProxy)
+}
+
 // Disconnect closes all SSH connections to all nodes.
 func (e *Engine) Disconnect() error {
 	nodes := e.FilterNodes(RoleAny)
@@ -264,23 +354,19 @@ func (e *Engine) KubeConfig(outputPath string) error {
 	server := e.FilterNodes(RoleServer)[0]
 
 	// Download kubeconfig.
-	newConfigBuffer := new(bytes.Buffer)
 	server.Logger.Info().Msg("Downloading kubeconfig")
-	if err := server.Do(sshx.Cmd{
-		Cmd:    "sudo cat /etc/rancher/k3s/k3s.yaml",
-		Stdout: newConfigBuffer,
-	}); err != nil {
+	newConfigBytes, err := server.Client.FetchKubeconfig()
+	if err != nil {
 		return err
 	}
 
 	// Fix API server URL.
-	newConfig, err := clientcmd.Load(newConfigBuffer.Bytes())
+	newConfig, err := clientcmd.Load(newConfigBytes)
 	if err != nil {
 		e.Logger.Error().Err(err).Msg("Failed to parse kubeconfig")
 		return err
 	}
-	// To my knowledge k3s always names its cluster, auth info and context "default".
-	newConfig.Clusters["default"].Server = e.serverURL
+	sshx.SetKubeconfigServer(newConfig, e.serverURL)
 
 	// Rename cluster, context and auth info for humans. If k3se is running as part of a
 	// CI pipeline we will not adjust the names to allow for further processing downstream.
@@ -300,6 +386,7 @@ func (e *Engine) KubeConfig(outputPath string) error {
 		}
 		context := "admin@" + cluster
 
+		// To my knowledge k3s always names its cluster, auth info and context "default".
 		newConfig.Clusters[cluster] = newConfig.Clusters["default"]
 		delete(newConfig.Clusters, "default")
 		newConfig.AuthInfos[context] = newConfig.AuthInfos["default"]
@@ -438,13 +525,24 @@ func (e *Engine) installControlPlanes() error {
 	return nil
 }
 
-// installWorkers installs the k3s worker nodes.
-// This function is a no-op if there are no workers.
+// installWorkers installs the k3s worker nodes concurrently and returns
+// the errors of all failed nodes. This function is a no-op if there
+// are no workers.
 func (e *Engine) installWorkers() error {
 	agents := e.FilterNodes(RoleAgent)
 
+	var errs sshx.MultiError
 	if len(agents) > 0 {
 		wg := sync.WaitGroup{}
+		mutex := sync.Mutex{}
+
+		fail := func(agent *Node, err error, msg string) {
+			agent.Logger.Error().Err(err).Msg(msg)
+
+			mutex.Lock()
+			errs = append(errs, fmt.Errorf("%s: %w", agent.SSH.Host, err))
+			mutex.Unlock()
+		}
 
 		for _, agent := range agents {
 			wg.Add(1)
@@ -453,7 +551,7 @@ func (e *Engine) installWorkers() error {
 				defer wg.Done()
 
 				if err := e.ConfigureNode(agent); err != nil {
-					agent.Logger.Error().Err(err).Msg("Failed to configure node")
+					fail(agent, err, "Failed to configure node")
 					return
 				}
 
@@ -469,7 +567,7 @@ func (e *Engine) installWorkers() error {
 					},
 					Stdout: agent,
 				}); err != nil {
-					agent.Logger.Error().Err(err).Msg("Failed to run installation script")
+					fail(agent, err, "Failed to run installation script")
 					return
 				}
 
@@ -479,5 +577,9 @@ func (e *Engine) installWorkers() error {
 		wg.Wait()
 	}
 
+	if errs.HasErrors() {
+		return errs
+	}
+
 	return nil
 }
@@ -2,7 +2,6 @@ package engine
 
 import (
 	"io"
-	"path/filepath"
 	"regexp"
 	"strings"
 
@@ -56,36 +55,22 @@ func (node *Node) Disconnect() error {
 	return nil
 }
 
-// Upload writes the specified content to the remote file on the node.
-func (node *Node) Upload(dst string, src io.Reader) error {
-	// Get base directory for the file.
-	dir := filepath.Dir(dst)
-
-	// Create directory if it does not exist.
-	if err := node.Client.SFTP.MkdirAll(dir); err != nil {
-		return err
+// Name returns the name of the node in Kubernetes, which is the
+// configured node name or the hostname of the node by default.
+func (node *Node) Name() (string, error) {
+	if node.Role == RoleServer && node.Server.NodeName != "" {
+		return node.Server.NodeName, nil
 	}
-
-	// Upload file.
-	file, err := node.Client.SFTP.Create(dst)
-	if err != nil {
-		return err
+	if node.Role == RoleAgent && node.Agent.NodeName != "" {
+		return node.Agent.NodeName, nil
 	}
-	defer file.Close()
 
-	// Restrict permissions.
-	if err := node.Client.SFTP.Chmod(dst, 0644); err != nil {
-		return err
-	}
-
-	// Empty existing file.
-	if err := file.Truncate(0); err != nil {
-		return err
-	}
+	return node.Client.GetHostname()
+}
 
-	// Overwrite file content.
-	_, err = io.Copy(file, src)
-	return err
+// Upload writes the specified content to the remote file on the node.
+func (node *Node) Upload(dst string, src io.Reader) error {
+	return node.Client.UploadStream(src, dst)
 }
 
 // Do executes a command on the node.
@@ -13,6 +13,15 @@ type Options struct {
 	Logger   *zerolog.Logger
 	SSHProxy *sshx.Client
 	Timeout  time.Duration
+	// MaxLoad is the 1 minute load average a node must drop
+	// below before k3s is installed. Zero disables the check.
+	MaxLoad float64
+	// MaxMemoryPressure is the ratio of used memory a node must drop
+	// below before k3s is installed or upgraded. Zero disables the check.
+	MaxMemoryPressure float64
+	// MaxCPUUsage is the percentage of busy CPU time above which
+	// a node is not upgraded. Zero disables the check.
+	MaxCPUUsage float64
 }
 
 // Option applies a configuration option
@@ -58,6 +67,33 @@ func WithSSHProxy(proxy *sshx.Client) Option {
 	}
 }
 
+// WithMaxLoad allows to wait for the load average of
+// a node to drop below the threshold before installing.
+func WithMaxLoad(threshold float64) Option {
+	return func(options *Options) error {
+		options.MaxLoad = threshold
+		return nil
+	}
+}
+
+// WithMaxMemoryPressure allows to wait for the memory pressure
+// of a node to drop below the threshold before installing.
+func WithMaxMemoryPressure(threshold float64) Option {
+	return func(options *Options) error {
+		options.MaxMemoryPressure = threshold
+		return nil
+	}
+}
+
+// WithMaxCPUUsage allows to skip the upgrade of a
+// node if its CPU usage exceeds the threshold.
+func WithMaxCPUUsage(threshold float64) Option {
+	return func(options *Options) error {
+		options.MaxCPUUsage = threshold
+		return nil
+	}
+}
+
 // WithTimeout allows to set a custom timeout.
 func WithTimeout(timeout time.Duration) Option {
 	return func(options *Options) error {
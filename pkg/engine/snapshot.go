@@ -0,0 +1,210 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nicklasfrahm/k3se/pkg/sshx"
+)
+
+const (
+	// snapshotManifestVersion is the version of the snapshot manifest format.
+	snapshotManifestVersion = 1
+	// snapshotManifestFile is the name of the snapshot manifest.
+	snapshotManifestFile = "manifest.json"
+	// snapshotKubeConfigFile is the name of the kubeconfig in a snapshot.
+	snapshotKubeConfigFile = "kubeconfig.yaml"
+)
+
+// ClusterSnapshot creates and restores snapshots of the cluster state. Each
+// snapshot is stored in a versioned directory below Dir, which contains the
+// k3s configuration of every node, the kubeconfig and a manifest. Please
+// note that etcd snapshots are kept on the server nodes by k3s.
+type ClusterSnapshot struct {
+	// Dir is the directory containing all snapshots.
+	Dir string
+	// Path is the directory of the most recently created snapshot.
+	Path string
+}
+
+// snapshotManifest describes the content of a snapshot.
+type snapshotManifest struct {
+	Version    int            `json:"version"`
+	Created    time.Time      `json:"created"`
+	KubeConfig string         `json:"kubeconfig"`
+	Nodes      []snapshotNode `json:"nodes"`
+}
+
+// snapshotNode describes the state of a single node in a snapshot.
+type snapshotNode struct {
+	Host         string `json:"host"`
+	Role         Role   `json:"role"`
+	Config       string `json:"config,omitempty"`
+	EtcdSnapshot string `json:"etcdSnapshot,omitempty"`
+}
+
+// Create creates a snapshot of the cluster. The engine must be connected.
+func (s *ClusterSnapshot) Create(eng *Engine) error {
+	created := time.Now()
+	snapshotPath := filepath.Join(s.Dir, created.Format("20060102150405"))
+	if err := os.MkdirAll(snapshotPath, 0700); err != nil {
+		return err
+	}
+
+	manifest := snapshotManifest{
+		Version: snapshotManifestVersion,
+		Created: created,
+	}
+
+	etcdSnapshotName := "k3se-" + created.Format("20060102150405")
+	for _, node := range eng.FilterNodes(RoleAny) {
+		entry := snapshotNode{
+			Host: node.SSH.Host,
+			Role: node.Role,
+		}
+
+		// Archive the k3s configuration of the node.
+		config, err := node.Client.ReadFile(sshx.K3SConfigPath)
+		if err != nil {
+			return err
+		}
+		entry.Config = node.SSH.Host + "-config.yaml"
+		if err := os.WriteFile(filepath.Join(snapshotPath, entry.Config), config, 0600); err != nil {
+			return err
+		}
+
+		// Create an etcd snapshot on servers with embedded etcd.
+		if node.Role == RoleServer {
+			if entry.EtcdSnapshot, err = createEtcdSnapshot(node, etcdSnapshotName); err != nil {
+				return err
+			}
+		}
+
+		manifest.Nodes = append(manifest.Nodes, entry)
+	}
+
+	// Archive the kubeconfig of the cluster.
+	server, err := eng.firstServer()
+	if err != nil {
+		return err
+	}
+	kubeConfig, err := server.Client.FetchKubeconfig()
+	if err != nil {
+		return err
+	}
+	manifest.KubeConfig = snapshotKubeConfigFile
+	if err := os.WriteFile(filepath.Join(snapshotPath, manifest.KubeConfig), kubeConfig, 0600); err != nil {
+		return err
+	}
+
+	manifestBytes, err := json.MarshalIndent(&manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(snapshotPath, snapshotManifestFile), manifestBytes, 0600); err != nil {
+		return err
+	}
+
+	eng.Logger.Info().Str("path", snapshotPath).Msg("Created cluster snapshot")
+	s.Path = snapshotPath
+
+	return nil
+}
+
+// Restore restores the k3s configuration of all nodes and the etcd snapshot
+// of the first server from the snapshot at the specified path. Additional
+// servers must rejoin the cluster after the restore. The engine must be
+// connected.
+func (s *ClusterSnapshot) Restore(path string, eng *Engine) error {
+	manifestBytes, err := os.ReadFile(filepath.Join(path, snapshotManifestFile))
+	if err != nil {
+		return err
+	}
+
+	manifest := new(snapshotManifest)
+	if err := json.Unmarshal(manifestBytes, manifest); err != nil {
+		return err
+	}
+	if manifest.Version != snapshotManifestVersion {
+		return fmt.Errorf("unsupported snapshot version: %d", manifest.Version)
+	}
+
+	entries := make(map[string]snapshotNode)
+	for _, entry := range manifest.Nodes {
+		entries[entry.Host] = entry
+	}
+
+	for _, node := range eng.FilterNodes(RoleAny) {
+		entry, ok := entries[node.SSH.Host]
+		if !ok || entry.Config == "" {
+			node.Logger.Warn().Msg("Node not found in snapshot")
+			continue
+		}
+
+		config, err := os.ReadFile(filepath.Join(path, entry.Config))
+		if err != nil {
+			return err
+		}
+
+		node.Logger.Info().Msg("Restoring k3s config")
+		if err := node.Client.AtomicWriteFile(sshx.K3SConfigPath, config, 0600); err != nil {
+			return err
+		}
+	}
+
+	server, err := eng.firstServer()
+	if err != nil {
+		return err
+	}
+	entry := entries[server.SSH.Host]
+	if entry.EtcdSnapshot == "" {
+		server.Logger.Warn().Msg("No etcd snapshot found for first server")
+		return nil
+	}
+
+	if err := server.Client.RestoreEtcdSnapshot(entry.EtcdSnapshot); err != nil {
+		return err
+	}
+
+	if len(eng.FilterNodes(RoleServer)) > 1 {
+		eng.Logger.Warn().Msg("Additional servers must rejoin the cluster after the restore")
+	}
+
+	return nil
+}
+
+// createEtcdSnapshot creates an etcd snapshot on the server and returns
+// the full name of the snapshot. Servers without embedded etcd are skipped.
+func createEtcdSnapshot(node *Node, name string) (string, error) {
+	if _, err := node.Client.GetEtcdStatus(); err != nil {
+		if errors.Is(err, sshx.ErrNotEtcdNode) {
+			node.Logger.Info().Msg("Skipping etcd snapshot without embedded etcd")
+			return "", nil
+		}
+		return "", err
+	}
+
+	if err := node.Client.CreateEtcdSnapshot(name); err != nil {
+		return "", err
+	}
+
+	snapshots, err := node.Client.ListEtcdSnapshots()
+	if err != nil {
+		return "", err
+	}
+
+	// As k3s appends the node name and a timestamp, the
+	// snapshot is identified by the prefix of its name.
+	for _, snapshot := range snapshots {
+		if strings.HasPrefix(snapshot.Name, name) {
+			return snapshot.Name, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: snapshot not listed", sshx.ErrEtcdSnapshotFailed)
+}
@@ -0,0 +1,198 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nicklasfrahm/k3se/pkg/sshx"
+)
+
+const (
+	// nodeReadyTimeout is the maximum time to wait for a node to become ready.
+	nodeReadyTimeout = time.Minute * 5
+	// nodePollInterval is the interval at which the node readiness is polled.
+	nodePollInterval = time.Second * 5
+)
+
+// NodeUpgradeResult describes the outcome of the upgrade of a single node.
+type NodeUpgradeResult struct {
+	Host string
+	Role Role
+	Err  error
+}
+
+// RollingUpgradeResult describes the outcome of a rolling upgrade.
+type RollingUpgradeResult struct {
+	Nodes []NodeUpgradeResult
+}
+
+// RollingUpgrade upgrades k3s on one node at a time, starting with the
+// servers followed by the agents. Each node is drained, upgraded and
+// uncordoned once it is ready again. Nodes whose resource usage exceeds
+// the thresholds of the engine options are not drained. The upgrade
+// stops at the first failure on purpose to prevent the cluster from
+// degrading further, which is why the error of the failed node is
+// returned instead of a MultiError. The results of all attempted
+// nodes are returned as well. The engine must be connected to all nodes.
+func (e *Engine) RollingUpgrade(targetVersion string) (*RollingUpgradeResult, error) {
+	result := new(RollingUpgradeResult)
+
+	// The first server is used to run all kubectl commands.
+	controller, err := e.firstServer()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := append(e.FilterNodes(RoleServer), e.FilterNodes(RoleAgent)...)
+	for _, node := range nodes {
+		err := e.upgradeNode(controller, node, targetVersion)
+
+		result.Nodes = append(result.Nodes, NodeUpgradeResult{
+			Host: node.SSH.Host,
+			Role: node.Role,
+			Err:  err,
+		})
+
+		if err != nil {
+			node.Logger.Error().Err(err).Msg("Failed to upgrade node")
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// upgradeNode upgrades k3s on a single node. The binary is downloaded
+// before the node is drained to keep the downtime short. If the upgrade
+// fails, k3s is started again and the node is uncordoned.
+func (e *Engine) upgradeNode(controller *Node, node *Node, targetVersion string) error {
+	name, err := node.Name()
+	if err != nil {
+		return err
+	}
+
+	if err := e.admitUpgrade(node); err != nil {
+		return err
+	}
+
+	node.Logger.Info().Str("version", targetVersion).Msg("Upgrading node")
+
+	binaryPath, err := node.Client.DownloadK3SBinary(targetVersion)
+	if err != nil {
+		return err
+	}
+	defer node.Do(sshx.Cmd{
+		Cmd: "rm -f " + binaryPath,
+	})
+
+	node.Logger.Info().Msg("Draining node")
+	if err := controller.Do(sshx.Cmd{
+		Cmd:    fmt.Sprintf("sudo k3s kubectl drain %s --ignore-daemonsets --delete-emptydir-data --timeout=%s", name, nodeReadyTimeout),
+		Stdout: node,
+	}); err != nil {
+		e.recoverNode(controller, node, name)
+		return err
+	}
+
+	if err := node.Client.StopK3S(); err != nil {
+		e.recoverNode(controller, node, name)
+		return err
+	}
+
+	if err := node.Client.ReplaceK3SBinary(binaryPath); err != nil {
+		e.recoverNode(controller, node, name)
+		return err
+	}
+
+	if err := node.Client.StartK3S(); err != nil {
+		return err
+	}
+
+	if err := e.waitForNode(controller, node, name); err != nil {
+		return err
+	}
+
+	node.Logger.Info().Msg("Uncordoning node")
+	return e.uncordonNode(controller, node, name)
+}
+
+// admitUpgrade returns an error if the node is too busy to be drained,
+// as evicted workloads and the restart of k3s add to the load of the
+// cluster. The thresholds of the engine options are used.
+func (e *Engine) admitUpgrade(node *Node) error {
+	if e.maxCPUUsage <= 0 && e.maxMemPressure <= 0 {
+		return nil
+	}
+
+	usage, err := node.Client.GetResourceUsage()
+	if err != nil {
+		return err
+	}
+
+	node.Logger.Info().
+		Float64("cpu", usage.CPUUsagePercent).
+		Float64("k3s_cpu", usage.K3SCPUUsagePercent).
+		Uint64("memory_available", usage.MemoryAvailableBytes).
+		Msg("Checking resource usage")
+
+	if e.maxCPUUsage > 0 && usage.CPUUsagePercent >= e.maxCPUUsage {
+		return fmt.Errorf("cpu usage %.2f%% not below %.2f%%", usage.CPUUsagePercent, e.maxCPUUsage)
+	}
+
+	if e.maxMemPressure > 0 && usage.MemoryTotalBytes > 0 {
+		pressure := 1 - float64(usage.MemoryAvailableBytes)/float64(usage.MemoryTotalBytes)
+		if pressure >= e.maxMemPressure {
+			return fmt.Errorf("memory pressure %.2f not below %.2f", pressure, e.maxMemPressure)
+		}
+	}
+
+	return nil
+}
+
+// recoverNode starts k3s and uncordons the node after a failed upgrade.
+// Errors are only logged as the error of the upgrade takes precedence.
+func (e *Engine) recoverNode(controller *Node, node *Node, name string) {
+	node.Logger.Warn().Msg("Recovering node after failed upgrade")
+	if err := node.Client.StartK3S(); err != nil {
+		node.Logger.Error().Err(err).Msg("Failed to start k3s")
+		return
+	}
+
+	if err := e.waitForNode(controller, node, name); err != nil {
+		node.Logger.Error().Err(err).Msg("Failed to wait for node")
+		return
+	}
+
+	if err := e.uncordonNode(controller, node, name); err != nil {
+		node.Logger.Error().Err(err).Msg("Failed to uncordon node")
+	}
+}
+
+// uncordonNode marks the Kubernetes node as schedulable.
+func (e *Engine) uncordonNode(controller *Node, node *Node, name string) error {
+	return controller.Do(sshx.Cmd{
+		Cmd:    "sudo k3s kubectl uncordon " + name,
+		Stdout: node,
+	})
+}
+
+// waitForNode blocks until the Kubernetes node is ready. The
+// API server may be unavailable if the controller itself is
+// being upgraded, which is why failures are retried.
+func (e *Engine) waitForNode(controller *Node, node *Node, name string) error {
+	if err := controller.Client.PollUntil(func(client *sshx.Client) (bool, error) {
+		if err := client.Do(sshx.Cmd{
+			Cmd:    fmt.Sprintf("sudo k3s kubectl wait --for=condition=Ready node/%s --timeout=%s", name, nodePollInterval),
+			Stdout: node,
+		}); err != nil {
+			node.Logger.Info().Msg("Waiting for node to become ready")
+			return false, err
+		}
+
+		return true, nil
+	}, nodePollInterval, nodeReadyTimeout); err != nil {
+		return fmt.Errorf("node %s not ready: %w", name, err)
+	}
+
+	return nil
+}
@@ -14,6 +14,8 @@ type Options struct {
 	ConfigPath     string
 	KubeConfigPath string
 	Logger         *zerolog.Logger
+	MaxLoad        float64
+	MaxMemPressure float64
 }
 
 // Option applies a configuration option
@@ -63,3 +65,21 @@ func WithKubeConfigPath(kubeConfigPath string) Option {
 		return nil
 	}
 }
+
+// WithMaxLoad overrides the load average a node must drop below
+// before k3s is installed. Zero disables the check.
+func WithMaxLoad(threshold float64) Option {
+	return func(options *Options) error {
+		options.MaxLoad = threshold
+		return nil
+	}
+}
+
+// WithMaxMemoryPressure overrides the ratio of used memory a node must
+// drop below before k3s is installed. Zero disables the check.
+func WithMaxMemoryPressure(threshold float64) Option {
+	return func(options *Options) error {
+		options.MaxMemPressure = threshold
+		return nil
+	}
+}
@@ -0,0 +1,61 @@
+package ops
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/nicklasfrahm/k3se/pkg/engine"
+)
+
+// TestSSH tests the SSH connectivity of all nodes without installing
+// anything and prints a summary. An error is returned if any node is
+// unreachable.
+func TestSSH(options ...Option) error {
+	// Fetch the options for this operation.
+	opts, err := GetDefaultOptions().Apply(options...)
+	if err != nil {
+		return err
+	}
+
+	config, err := engine.LoadConfig(opts.ConfigPath)
+	if err != nil {
+		return err
+	}
+
+	eng, err := engine.New(engine.WithLogger(opts.Logger))
+	if err != nil {
+		return err
+	}
+
+	if err := eng.SetSpec(config); err != nil {
+		return err
+	}
+
+	results, err := eng.Ping()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "HOST\tUSER\tRESULT\tLATENCY")
+	for _, result := range results {
+		if result.Err != nil {
+			failed += 1
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", result.Host, result.User, result.Err, "-")
+			continue
+		}
+
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", result.Host, result.User, "ok", result.Latency)
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("ssh connection failed for %d of %d nodes", failed, len(results))
+	}
+
+	return nil
+}
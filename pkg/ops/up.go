@@ -16,7 +16,11 @@ func Up(options ...Option) error {
 		return err
 	}
 
-	eng, err := engine.New(engine.WithLogger(opts.Logger))
+	eng, err := engine.New(
+		engine.WithLogger(opts.Logger),
+		engine.WithMaxLoad(opts.MaxLoad),
+		engine.WithMaxMemoryPressure(opts.MaxMemPressure),
+	)
 	if err != nil {
 		return err
 	}
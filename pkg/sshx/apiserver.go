@@ -0,0 +1,239 @@
+package sshx
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	// K3SAuditPolicyPath is the location of the audit policy of the API server.
+	K3SAuditPolicyPath = "/etc/rancher/k3s/audit.yaml"
+	// K3SEncryptionConfigPath is the location of the encryption config of the API server.
+	K3SEncryptionConfigPath = "/etc/rancher/k3s/encryption-config.yaml"
+	// K3SAdmissionConfigPath is the location of the admission config of the API server.
+	K3SAdmissionConfigPath = "/etc/rancher/k3s/admission-config.yaml"
+)
+
+// podSecurityProfiles are the profiles of the Pod Security Standards.
+var podSecurityProfiles = map[string]bool{
+	"privileged": true,
+	"baseline":   true,
+	"restricted": true,
+}
+
+// podSecurityAdmissionConfig is the admission config that enforces a
+// profile of the Pod Security Standards in all namespaces except for
+// "kube-system", which contains the privileged components of k3s.
+const podSecurityAdmissionConfig = `apiVersion: apiserver.config.k8s.io/v1
+kind: AdmissionConfiguration
+plugins:
+  - name: PodSecurity
+    configuration:
+      apiVersion: pod-security.admission.config.k8s.io/v1
+      kind: PodSecurityConfiguration
+      defaults:
+        enforce: %[1]q
+        enforce-version: latest
+        audit: %[1]q
+        audit-version: latest
+        warn: %[1]q
+        warn-version: latest
+      exemptions:
+        usernames: []
+        runtimeClasses: []
+        namespaces: [kube-system]
+`
+
+// EnableK3SAuditLog enables the audit log of the Kubernetes API server, as
+// required by the CIS Kubernetes benchmark. The local audit policy is
+// uploaded to the node and the API server is configured to write the
+// audit log to the specified path on the node. K3s is restarted and the
+// function blocks until k3s is ready again.
+func (client *Client) EnableK3SAuditLog(policyPath, logPath string) error {
+	if err := client.ensureK3SServer(); err != nil {
+		return err
+	}
+
+	client.Logger.Info().Str("path", logPath).Msg("Enabling audit log")
+	if err := client.installFile(policyPath, K3SAuditPolicyPath, 0600); err != nil {
+		return err
+	}
+
+	return client.setK3SAPIServerArgs(map[string]string{
+		"audit-log-path":    logPath,
+		"audit-policy-file": K3SAuditPolicyPath,
+	})
+}
+
+// GetK3SAPIServerArgs returns the arguments of the Kubernetes API server
+// in the "kube-apiserver-arg" of the k3s configuration as a map of names
+// to values, such as "audit-log-path". This allows to verify that the
+// flags required for production clusters are configured.
+func (client *Client) GetK3SAPIServerArgs() (map[string]string, error) {
+	config, err := client.readK3SConfigMap()
+	if err != nil {
+		return nil, err
+	}
+
+	args := make(map[string]string)
+	for _, arg := range k3sAPIServerArgs(config) {
+		name, value, _ := strings.Cut(arg, "=")
+		args[name] = value
+	}
+
+	return args, nil
+}
+
+// EncryptK3SSecrets enables the encryption of secrets at rest. The local
+// encryption config, which contains the encryption keys, is uploaded to
+// the node and passed to the API server. K3s is restarted before all
+// existing secrets are replaced to encrypt them with the new keys. This
+// is only supported on clusters with a single server, as other servers
+// could not read the secrets encrypted with keys they do not know.
+func (client *Client) EncryptK3SSecrets(encryptionKeyPath string) error {
+	nodes, err := client.GetK3SServerNodes()
+	if err != nil {
+		return err
+	}
+
+	servers := 0
+	for _, node := range nodes {
+		if slices.Contains(node.Roles, "control-plane") {
+			servers++
+		}
+	}
+	if servers > 1 {
+		return fmt.Errorf("encryption of secrets is only supported with a single server, found %d", servers)
+	}
+
+	client.Logger.Info().Msg("Enabling encryption of secrets")
+	if err := client.installFile(encryptionKeyPath, K3SEncryptionConfigPath, 0600); err != nil {
+		return err
+	}
+
+	if err := client.setK3SAPIServerArgs(map[string]string{
+		"encryption-provider-config": K3SEncryptionConfigPath,
+	}); err != nil {
+		return err
+	}
+
+	client.Logger.Info().Msg("Encrypting existing secrets")
+	// Fail if listing the secrets fails instead of only reporting the
+	// error of replacing them.
+	_, err = client.output(Cmd{
+		Cmd: "set -o pipefail && sudo k3s kubectl get secrets --all-namespaces -o json | sudo k3s kubectl replace -f -",
+	})
+
+	return err
+}
+
+// SetPodSecurityAdmission enforces a profile of the Pod Security Standards,
+// which is one of "privileged", "baseline" or "restricted", in all
+// namespaces via the admission config of the API server. This replaces
+// the PodSecurityPolicy, which was removed in Kubernetes 1.25. It returns
+// ErrUnknownPodSecurityProfile if the profile is not known.
+func (client *Client) SetPodSecurityAdmission(profile string) error {
+	if !podSecurityProfiles[profile] {
+		return fmt.Errorf("%w: %s", ErrUnknownPodSecurityProfile, profile)
+	}
+
+	if err := client.ensureK3SServer(); err != nil {
+		return err
+	}
+
+	client.Logger.Info().Str("profile", profile).Msg("Setting pod security admission")
+	config := fmt.Sprintf(podSecurityAdmissionConfig, profile)
+	if err := client.AtomicWriteFile(K3SAdmissionConfigPath, []byte(config), 0600); err != nil {
+		return err
+	}
+
+	return client.setK3SAPIServerArgs(map[string]string{
+		"admission-control-config-file": K3SAdmissionConfigPath,
+	})
+}
+
+// installFile uploads a local file to a temporary location and moves it
+// into place with root privileges, which allows to write to directories
+// that are not writable for the user of the SSH connection.
+func (client *Client) installFile(localPath, remotePath string, mode os.FileMode) error {
+	tmpPath := "/tmp/k3se-" + randomHex(8)
+	if err := client.UploadFile(localPath, tmpPath); err != nil {
+		return err
+	}
+
+	defer client.invalidateFile(remotePath)
+	return client.Do(Cmd{
+		Cmd: fmt.Sprintf("sudo install -D -m %o %s %s && rm -f %s", mode.Perm(), tmpPath, remotePath, tmpPath),
+	})
+}
+
+// k3sAPIServerArgs returns the "kube-apiserver-arg" of the k3s config,
+// which may be specified as a single string or as a list.
+func k3sAPIServerArgs(config map[string]interface{}) []string {
+	var args []string
+
+	switch value := config["kube-apiserver-arg"].(type) {
+	case string:
+		args = []string{value}
+	case []interface{}:
+		for _, arg := range value {
+			args = append(args, fmt.Sprint(arg))
+		}
+	}
+
+	return args
+}
+
+// setK3SAPIServerArgs sets arguments of the Kubernetes API server in the
+// "kube-apiserver-arg" of the k3s configuration. Existing arguments with
+// the same name are replaced and repeated ones are removed. K3s is
+// restarted and the function blocks until k3s is ready again.
+func (client *Client) setK3SAPIServerArgs(args map[string]string) error {
+	config, err := client.readK3SConfigMap()
+	if err != nil {
+		return err
+	}
+
+	var merged []interface{}
+	seen := make(map[string]bool)
+	for _, arg := range k3sAPIServerArgs(config) {
+		name, _, _ := strings.Cut(arg, "=")
+		if value, ok := args[name]; ok {
+			// Drop repeated arguments, as they would only be
+			// replaced with the same value.
+			if seen[name] {
+				continue
+			}
+			arg = name + "=" + value
+			seen[name] = true
+		}
+		merged = append(merged, arg)
+	}
+
+	names := make([]string, 0, len(args))
+	for name := range args {
+		if !seen[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		merged = append(merged, name+"="+args[name])
+	}
+
+	config["kube-apiserver-arg"] = merged
+
+	if err := client.writeK3SConfigMap(config); err != nil {
+		return err
+	}
+
+	if err := client.ServiceRestart(client.k3sService()); err != nil {
+		return err
+	}
+
+	return client.WaitForK3SReady(k3sReadyTimeout)
+}
@@ -0,0 +1,84 @@
+package sshx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// awsSecret is the JSON representation of SSH credentials
+// stored in AWS Secrets Manager. Only set fields are applied.
+type awsSecret struct {
+	Host        string `json:"host"`
+	Port        int    `json:"port"`
+	User        string `json:"user"`
+	Password    string `json:"password"`
+	Key         string `json:"key"`
+	Passphrase  string `json:"passphrase"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// FromAWSSecret retrieves the secret with the given ARN from AWS Secrets
+// Manager and applies it to the config. The secret may either be a JSON
+// object, such as {"user": "admin", "password": "..."}, or the plain PEM
+// content of a private key. The default AWS credential chain is used.
+func (config *Config) FromAWSSecret(secretARN string) error {
+	ctx := context.Background()
+
+	awsConfig, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	output, err := secretsmanager.NewFromConfig(awsConfig).GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(secretARN),
+	})
+	if err != nil {
+		return err
+	}
+
+	value := strings.TrimSpace(aws.ToString(output.SecretString))
+	if value == "" {
+		return errors.New("secret has no string value")
+	}
+
+	// Private keys may be stored as plain PEM content.
+	if strings.HasPrefix(value, "-----BEGIN") {
+		config.Key = value
+		return nil
+	}
+
+	secret := new(awsSecret)
+	if err := json.Unmarshal([]byte(value), secret); err != nil {
+		return err
+	}
+
+	if secret.Host != "" {
+		config.Host = secret.Host
+	}
+	if secret.Port != 0 {
+		config.Port = secret.Port
+	}
+	if secret.User != "" {
+		config.User = secret.User
+	}
+	if secret.Password != "" {
+		config.Password = secret.Password
+	}
+	if secret.Key != "" {
+		config.Key = secret.Key
+	}
+	if secret.Passphrase != "" {
+		config.Passphrase = secret.Passphrase
+	}
+	if secret.Fingerprint != "" {
+		config.Fingerprint = secret.Fingerprint
+	}
+
+	return nil
+}
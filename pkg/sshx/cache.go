@@ -0,0 +1,97 @@
+package sshx
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// fileCache is a least-recently-used cache for the content of remote files.
+type fileCache struct {
+	sync.Mutex
+
+	maxEntries int
+	ttl        time.Duration
+	entries    map[string]*list.Element
+	order      *list.List
+}
+
+// fileCacheEntry is the cached content of a single remote file.
+type fileCacheEntry struct {
+	path    string
+	content []byte
+	expires time.Time
+}
+
+// newFileCache creates a new file cache.
+func newFileCache(maxEntries int, ttl time.Duration) *fileCache {
+	return &fileCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// get returns the cached content of a file if it has not expired yet.
+func (c *fileCache) get(path string) ([]byte, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	element, ok := c.entries[path]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*fileCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, path)
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	return entry.content, true
+}
+
+// set caches the content of a file and evicts the least
+// recently used entry if the cache exceeds its size.
+func (c *fileCache) set(path string, content []byte) {
+	c.Lock()
+	defer c.Unlock()
+
+	if element, ok := c.entries[path]; ok {
+		c.order.Remove(element)
+	}
+
+	c.entries[path] = c.order.PushFront(&fileCacheEntry{
+		path:    path,
+		content: content,
+		expires: time.Now().Add(c.ttl),
+	})
+
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*fileCacheEntry).path)
+	}
+}
+
+// invalidate removes a file from the cache.
+func (c *fileCache) invalidate(path string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if element, ok := c.entries[path]; ok {
+		c.order.Remove(element)
+		delete(c.entries, path)
+	}
+}
+
+// invalidateFile removes a file from the cache of the client if
+// caching is enabled. It must be called after writing a file.
+func (client *Client) invalidateFile(path string) {
+	if client.fileCache != nil {
+		client.fileCache.invalidate(path)
+	}
+}
@@ -0,0 +1,243 @@
+package sshx
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// K3SAPIServerAddress is the address of the k3s API server on a server node.
+	K3SAPIServerAddress = "127.0.0.1:6443"
+	// K3STLSDir is the directory containing the certificates of a k3s server.
+	K3STLSDir = "/var/lib/rancher/k3s/server/tls"
+)
+
+// GetAPIServerCert returns the certificate chain presented by the k3s API
+// server. The TLS connection is tunneled through the SSH connection, which
+// allows to inspect the certificates without exposing the API server or
+// requiring openssl on the node.
+func (client *Client) GetAPIServerCert() ([]*x509.Certificate, error) {
+	conn, err := client.SSH.Dial("tcp", K3SAPIServerAddress)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// The certificates are only inspected and not trusted, which
+	// is why it is safe to skip the verification of the chain.
+	tlsConn := tls.Client(conn, &tls.Config{
+		InsecureSkipVerify: true,
+	})
+	defer tlsConn.Close()
+
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+
+	return tlsConn.ConnectionState().PeerCertificates, nil
+}
+
+// GetCertExpiry returns the expiry date of the first
+// certificate in the remote PEM-encoded file.
+func (client *Client) GetCertExpiry(certPath string) (time.Time, error) {
+	content, err := client.ReadFile(certPath)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	cert, err := parseCertificate(content)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", certPath, err)
+	}
+
+	return cert.NotAfter, nil
+}
+
+// WarnIfCertExpiresWithin logs a warning and returns ErrCertExpiringSoon
+// if the remote certificate expires within the specified duration.
+func (client *Client) WarnIfCertExpiresWithin(certPath string, d time.Duration) error {
+	expiry, err := client.GetCertExpiry(certPath)
+	if err != nil {
+		return err
+	}
+
+	if time.Until(expiry) < d {
+		client.Logger.Warn().Str("cert", certPath).Time("expiry", expiry).Msg("Certificate expires soon")
+		return ErrCertExpiringSoon
+	}
+
+	return nil
+}
+
+// k3sDefaultSANs are the SANs that k3s always adds to the certificate of
+// the API server in addition to the hostname and the IP of the node.
+var k3sDefaultSANs = []string{
+	"kubernetes",
+	"kubernetes.default",
+	"kubernetes.default.svc",
+	"kubernetes.default.svc.cluster.local",
+	"localhost",
+	"127.0.0.1",
+	"::1",
+}
+
+// GetK3SSAN returns the SANs of the certificate of the k3s API server,
+// which are the "tls-san" of the k3s configuration and the SANs k3s
+// adds automatically, such as the hostname and the IP of the node.
+// This allows to check if a new address must be added to the SANs.
+func (client *Client) GetK3SSAN() ([]string, error) {
+	config, err := client.GetK3SConfig()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	hostname, err := client.GetHostname()
+	if err != nil {
+		return nil, err
+	}
+
+	sans := append([]string{}, k3sDefaultSANs...)
+	sans = append(sans, hostname)
+
+	// K3s uses the IP of the interface with the default route.
+	route, err := client.output(Cmd{
+		Cmd: "ip route get 1.1.1.1",
+	})
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(route)
+	for i, field := range fields {
+		if field == "src" && i+1 < len(fields) {
+			sans = append(sans, fields[i+1])
+		}
+	}
+
+	if config != nil {
+		sans = append(sans, config.TLSSan...)
+	}
+
+	// Remove duplicates while keeping the order.
+	seen := make(map[string]bool)
+	unique := sans[:0]
+	for _, san := range sans {
+		if !seen[san] {
+			seen[san] = true
+			unique = append(unique, san)
+		}
+	}
+
+	return unique, nil
+}
+
+// AddK3SSAN adds a SAN, such as the IP of a new load balancer, to the
+// "tls-san" of the k3s configuration and rotates the certificates to
+// apply it, which restarts k3s. It is a no-op if the SAN already exists.
+func (client *Client) AddK3SSAN(san string) error {
+	if err := client.ensureK3SServer(); err != nil {
+		return err
+	}
+
+	config, err := client.readK3SConfigMap()
+	if err != nil {
+		return err
+	}
+
+	// The SANs may be specified as a single string or as a list.
+	var sans []interface{}
+	switch value := config["tls-san"].(type) {
+	case string:
+		sans = []interface{}{value}
+	case []interface{}:
+		sans = value
+	}
+
+	for _, existing := range sans {
+		if fmt.Sprint(existing) == san {
+			client.Logger.Info().Str("san", san).Msg("SAN already exists")
+			return nil
+		}
+	}
+
+	client.Logger.Info().Str("san", san).Msg("Adding SAN")
+	config["tls-san"] = append(sans, san)
+	if err := client.writeK3SConfigMap(config); err != nil {
+		return err
+	}
+
+	return client.RenewK3SCerts()
+}
+
+// K3SCertsExpiringSoon reports whether any certificate of the k3s server,
+// including the certificates of etcd, expires within the threshold. The
+// expiring certificates are logged.
+func (client *Client) K3SCertsExpiringSoon(threshold time.Duration) (bool, error) {
+	if err := client.ensureK3SServer(); err != nil {
+		return false, err
+	}
+
+	certs, err := client.listCerts(K3STLSDir)
+	if err != nil {
+		return false, err
+	}
+
+	expiring := false
+	for _, certPath := range certs {
+		expiry, err := client.GetCertExpiry(certPath)
+		if err != nil {
+			return false, err
+		}
+
+		if time.Until(expiry) < threshold {
+			client.Logger.Warn().Str("cert", certPath).Time("expiry", expiry).Msg("Certificate expires soon")
+			expiring = true
+		}
+	}
+
+	return expiring, nil
+}
+
+// listCerts recursively lists all certificates of a remote directory.
+func (client *Client) listCerts(dir string) ([]string, error) {
+	entries, err := client.ListDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []string
+	for _, entry := range entries {
+		entryPath := path.Join(dir, entry)
+
+		if strings.HasSuffix(entry, "/") {
+			nested, err := client.listCerts(entryPath)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, nested...)
+			continue
+		}
+
+		if strings.HasSuffix(entry, ".crt") {
+			certs = append(certs, entryPath)
+		}
+	}
+
+	return certs, nil
+}
+
+// parseCertificate parses the first certificate of a PEM-encoded file.
+func parseCertificate(content []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate found")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
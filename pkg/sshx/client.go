@@ -1,30 +1,99 @@
 package sshx
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"os"
 	"os/user"
+	"strings"
+	"time"
 
 	"github.com/pkg/sftp"
+	"github.com/rs/zerolog"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	// DefaultPort is the default port of the SSH server.
+	DefaultPort = 22
+	// DefaultUser is the default user to log in as.
+	DefaultUser = "root"
+	// DefaultTimeout is the default timeout for establishing a connection.
+	DefaultTimeout = time.Second * 5
 )
 
 // Config is a flat configuration for an SSH connection.
 type Config struct {
-	Host              string   `yaml:"host"`
-	Port              int      `yaml:"port"`
-	User              string   `yaml:"user"`
-	Password          string   `yaml:"password"`
-	KeyFile           string   `yaml:"key-file"`
-	Key               string   `yaml:"key"`
-	Passphrase        string   `yaml:"passphrase"`
-	Fingerprint       string   `yaml:"fingerprint"`
-	HostKeyAlgorithms []string `yaml:"host-key-algorithms"`
-	KeyExchanges      []string `yaml:"key-exchanges"`
-	Ciphers           []string `yaml:"ciphers"`
-	MACs              []string `yaml:"macs"`
+	Host              string        `yaml:"host"`
+	Port              int           `yaml:"port"`
+	User              string        `yaml:"user"`
+	Password          string        `yaml:"password"`
+	KeyFile           string        `yaml:"key-file"`
+	Key               string        `yaml:"key"`
+	Passphrase        string        `yaml:"passphrase"`
+	Fingerprint       string        `yaml:"fingerprint"`
+	HostKeyAlgorithms []string      `yaml:"host-key-algorithms"`
+	KeyExchanges      []string      `yaml:"key-exchanges"`
+	Ciphers           []string      `yaml:"ciphers"`
+	MACs              []string      `yaml:"macs"`
+	Timeout           time.Duration `yaml:"timeout"`
+	KnownHostsFile    string        `yaml:"known-hosts-file"`
+
+	// PassphrasePrompt is called to obtain the passphrase of an encrypted
+	// private key if no passphrase is configured. The passphrase is then
+	// cached in the config for subsequent connections.
+	PassphrasePrompt func(keyPath string) (string, error) `yaml:"-"`
+}
+
+// WithDefaults fills all unset fields with their default values
+// and returns the modified config. This allows to inspect the
+// effective configuration before establishing a connection.
+func (config *Config) WithDefaults() *Config {
+	if config.Port == 0 {
+		config.Port = DefaultPort
+	}
+	if config.User == "" {
+		config.User = DefaultUser
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	return config
+}
+
+// WithPort sets the port of the SSH server and returns the modified config.
+func (config *Config) WithPort(port int) *Config {
+	config.Port = port
+	return config
+}
+
+// WithUser sets the user to log in as and returns the modified config.
+func (config *Config) WithUser(user string) *Config {
+	config.User = user
+	return config
+}
+
+// WithPassword sets the password of the user and returns the modified config.
+func (config *Config) WithPassword(password string) *Config {
+	config.Password = password
+	return config
+}
+
+// WithKeyFile sets the path of the private key and returns the modified config.
+func (config *Config) WithKeyFile(keyFile string) *Config {
+	config.KeyFile = keyFile
+	return config
+}
+
+// WithFingerprint sets the expected fingerprint of the host
+// key of the SSH server and returns the modified config.
+func (config *Config) WithFingerprint(fingerprint string) *Config {
+	config.Fingerprint = fingerprint
+	return config
 }
 
 // Client is an augmented SSH client.
@@ -33,6 +102,52 @@ type Client struct {
 
 	SSH  *ssh.Client
 	SFTP *sftp.Client
+
+	plugins []Plugin
+}
+
+// Sanitize normalizes user-provided input by trimming surrounding spaces,
+// removing the "ssh://" scheme from the host and resolving the home
+// directory in file paths. Passwords and passphrases are not modified
+// as spaces may be part of the secret.
+func (config *Config) Sanitize() {
+	config.Host = strings.ToLower(strings.TrimSpace(config.Host))
+	config.Host = strings.TrimPrefix(config.Host, "ssh://")
+	config.User = strings.TrimSpace(config.User)
+	config.KeyFile = strings.TrimSpace(config.KeyFile)
+	config.Key = strings.TrimSpace(config.Key)
+	config.Fingerprint = strings.TrimSpace(config.Fingerprint)
+	config.KnownHostsFile = strings.TrimSpace(config.KnownHostsFile)
+
+	config.HostKeyAlgorithms = trimAll(config.HostKeyAlgorithms)
+	config.KeyExchanges = trimAll(config.KeyExchanges)
+	config.Ciphers = trimAll(config.Ciphers)
+	config.MACs = trimAll(config.MACs)
+
+	// Errors are ignored here as the paths are resolved
+	// again once the files are actually being read.
+	if keyFile, err := expandHome(config.KeyFile); err == nil {
+		config.KeyFile = keyFile
+	}
+	if knownHostsFile, err := expandHome(config.KnownHostsFile); err == nil {
+		config.KnownHostsFile = knownHostsFile
+	}
+}
+
+// trimAll returns a copy of the list with surrounding spaces
+// trimmed from all items. It does not modify the original list
+// as it may be shared between configs.
+func trimAll(list []string) []string {
+	if list == nil {
+		return nil
+	}
+
+	trimmed := make([]string, len(list))
+	for i, item := range list {
+		trimmed[i] = strings.TrimSpace(item)
+	}
+
+	return trimmed
 }
 
 // NewClient creates a new SSH client and a new SFTP client based
@@ -48,13 +163,14 @@ func NewClient(config *Config, options ...Option) (*Client, error) {
 		Options: opts,
 	}
 
-	// Set default connection options.
-	if config.Port == 0 {
-		config.Port = 22
-	}
-	if config.User == "" {
-		config.User = "root"
+	config.Sanitize()
+
+	// Set default connection options. The timeout of the
+	// options is used unless the config specifies one.
+	if config.Timeout == 0 {
+		config.Timeout = client.Timeout
 	}
+	config.WithDefaults()
 
 	normalizedConfig, err := client.normalizeConfig(config)
 	if err != nil {
@@ -91,19 +207,31 @@ func NewClient(config *Config, options ...Option) (*Client, error) {
 	return client, nil
 }
 
+// ToSSHClientConfig creates a client config that is compatible with the
+// standard library after applying the defaults. This allows to establish
+// raw SSH connections while reusing the key loading and host key checks.
+func (config *Config) ToSSHClientConfig() (*ssh.ClientConfig, error) {
+	logger := zerolog.Nop()
+
+	return config.WithDefaults().clientConfig(&logger)
+}
+
 // normalizeConfig creates a new client config that is compatible with the standard library.
 func (client *Client) normalizeConfig(config *Config) (*ssh.ClientConfig, error) {
+	return config.clientConfig(client.Logger)
+}
+
+// clientConfig creates a new client config that is compatible with
+// the standard library. Security warnings are written to the logger.
+func (config *Config) clientConfig(logger *zerolog.Logger) (*ssh.ClientConfig, error) {
 	// Load the private key. A key that is specified directly takes
 	// precedence over a key file.
 	key := config.Key
 	if key == "" && config.KeyFile != "" {
 		// Resolve the home directory if necessary.
-		if config.KeyFile[0] == '~' {
-			userInfo, err := user.Current()
-			if err != nil {
-				return nil, err
-			}
-			config.KeyFile = userInfo.HomeDir + config.KeyFile[1:]
+		var err error
+		if config.KeyFile, err = expandHome(config.KeyFile); err != nil {
+			return nil, err
 		}
 
 		keyBytes, err := os.ReadFile(config.KeyFile)
@@ -119,25 +247,16 @@ func (client *Client) normalizeConfig(config *Config) (*ssh.ClientConfig, error)
 	// password.
 	var authMethod ssh.AuthMethod
 	if key != "" {
-		// Use passphrase to decrypt the private key.
-		if config.Passphrase != "" {
-			signer, err := ssh.ParsePrivateKeyWithPassphrase([]byte(key), []byte(config.Passphrase))
-			if err != nil {
-				return nil, err
-			}
-			authMethod = ssh.PublicKeys(signer)
-		} else {
-			signer, err := ssh.ParsePrivateKey([]byte(key))
-			if err != nil {
-				return nil, err
-			}
-			authMethod = ssh.PublicKeys(signer)
+		signer, err := config.parsePrivateKey([]byte(key))
+		if err != nil {
+			return nil, err
 		}
+		authMethod = ssh.PublicKeys(signer)
 	} else if config.Password != "" {
 		// Fall back to password authentication.
 		authMethod = ssh.Password(config.Password)
-		client.Logger.Warn().Msg("Using password authentication is insecure!")
-		client.Logger.Warn().Msg("Please consider using public key authentication!")
+		logger.Warn().Msg("Using password authentication is insecure!")
+		logger.Warn().Msg("Please consider using public key authentication!")
 	} else {
 		return nil, errors.New("no authentication method specified")
 	}
@@ -152,10 +271,14 @@ func (client *Client) normalizeConfig(config *Config) (*ssh.ClientConfig, error)
 			}
 			return nil
 		}
+	} else if knownHostsCallback, err := knownHostsCallback(config.KnownHostsFile, logger); err != nil {
+		return nil, err
+	} else if knownHostsCallback != nil {
+		hostKeyCallback = knownHostsCallback
 	} else {
-		client.Logger.Warn().Msg("Skipping host key verification is insecure!")
-		client.Logger.Warn().Msg("This allows for person-in-the-middle attacks!")
-		client.Logger.Warn().Msg("Please consider using fingerprint verification!")
+		logger.Warn().Msg("Skipping host key verification is insecure!")
+		logger.Warn().Msg("This allows for person-in-the-middle attacks!")
+		logger.Warn().Msg("Please consider using fingerprint verification!")
 		hostKeyCallback = ssh.InsecureIgnoreHostKey()
 	}
 
@@ -169,14 +292,116 @@ func (client *Client) normalizeConfig(config *Config) (*ssh.ClientConfig, error)
 		Auth:              []ssh.AuthMethod{authMethod},
 		HostKeyCallback:   hostKeyCallback,
 		User:              config.User,
-		Timeout:           client.Timeout,
+		Timeout:           config.Timeout,
 		HostKeyAlgorithms: config.HostKeyAlgorithms,
 		Config:            connConfig,
 	}, nil
 }
 
+// parsePrivateKey parses a private key and decrypts it with the configured
+// passphrase. If the key is encrypted, but no passphrase is configured, the
+// passphrase prompt is used to obtain the passphrase.
+func (config *Config) parsePrivateKey(key []byte) (ssh.Signer, error) {
+	// Use passphrase to decrypt the private key.
+	if config.Passphrase != "" {
+		return ssh.ParsePrivateKeyWithPassphrase(key, []byte(config.Passphrase))
+	}
+
+	signer, err := ssh.ParsePrivateKey(key)
+
+	var missingErr *ssh.PassphraseMissingError
+	if errors.As(err, &missingErr) && config.PassphrasePrompt != nil {
+		passphrase, err := config.PassphrasePrompt(config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		if signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase)); err != nil {
+			return nil, err
+		}
+
+		config.Passphrase = passphrase
+		return signer, nil
+	}
+
+	return signer, err
+}
+
+// knownHostsCallback creates a host key callback that verifies host keys
+// against the known hosts file. Hosts that are not listed yet are accepted
+// with a warning, but a mismatching host key is always rejected. The
+// verification is opt-in, so no callback is returned if no file is set.
+func knownHostsCallback(knownHostsFile string, logger *zerolog.Logger) (ssh.HostKeyCallback, error) {
+	if knownHostsFile == "" {
+		return nil, nil
+	}
+
+	knownHostsFile, err := expandHome(knownHostsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	callback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(hostname string, remote net.Addr, pubKey ssh.PublicKey) error {
+		err := callback(hostname, remote, pubKey)
+
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 {
+			logger.Warn().Str("host", hostname).Msg("Host is not listed in known hosts file!")
+			logger.Warn().Msg("Please consider using fingerprint verification!")
+			return nil
+		}
+
+		return err
+	}, nil
+}
+
+// expandHome resolves a leading "~" in a path to the home directory of the current user.
+func expandHome(path string) (string, error) {
+	if path == "" || path[0] != '~' {
+		return path, nil
+	}
+
+	userInfo, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+
+	return userInfo.HomeDir + path[1:], nil
+}
+
+// Ping sends a keepalive request to the remote host
+// and returns the round-trip time of the request.
+func (client *Client) Ping() (time.Duration, error) {
+	start := time.Now()
+
+	// The reply does not matter as servers will reject unknown requests,
+	// but it indicates that the connection is alive and responsive.
+	if _, _, err := client.SSH.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+		return 0, err
+	}
+
+	return time.Since(start), nil
+}
+
 // Do executes a command on the remote host.
 func (client *Client) Do(command Cmd) error {
+	return client.DoContext(context.Background(), command)
+}
+
+// DoContext executes a command on the remote host. If the context is done
+// before the command terminates, the remote process is killed and the
+// error of the context is returned.
+func (client *Client) DoContext(ctx context.Context, command Cmd) error {
+	command, err := client.applyPlugins(command)
+	if err != nil {
+		return err
+	}
+
 	session, err := client.SSH.NewSession()
 	if err != nil {
 		return err
@@ -189,7 +414,47 @@ func (client *Client) Do(command Cmd) error {
 	session.Stderr = command.Stderr
 
 	// Execute the command.
-	return session.Run(command.String())
+	if err := session.Start(command.String()); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		// Not all SSH servers support signals, but closing
+		// the session will terminate the command as well.
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		return ctx.Err()
+	}
+}
+
+// output executes a command on the remote host and returns its trimmed
+// standard output. If the command fails, the standard error is included
+// in the returned error to make it easier to debug remote failures.
+func (client *Client) output(command Cmd) (string, error) {
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+
+	command.Stdout = stdout
+	if command.Stderr == nil {
+		command.Stderr = stderr
+	}
+
+	if err := client.Do(command); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
 }
 
 // Close closes the SFTP connection first as it
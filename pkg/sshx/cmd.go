@@ -3,6 +3,7 @@ package sshx
 import (
 	"fmt"
 	"io"
+	"strings"
 )
 
 // Cmd describes a command to be executed on the remote host.
@@ -24,12 +25,12 @@ func (c *Cmd) String() string {
 	// Note that we also need to wrap the command in a
 	// shell if we want to inject environment variables.
 	if c.Shell || c.Env != nil {
-		cmd = fmt.Sprintf("sh -c '%s'", c.Cmd)
+		cmd = fmt.Sprintf("sh -c %s", shellQuote(c.Cmd))
 	}
 
 	if c.Env != nil {
 		for k, v := range c.Env {
-			cmd = fmt.Sprintf("%s='%s' %s", k, v, cmd)
+			cmd = fmt.Sprintf("%s=%s %s", k, shellQuote(v), cmd)
 		}
 
 		cmd = fmt.Sprintf("env %s", cmd)
@@ -37,3 +38,9 @@ func (c *Cmd) String() string {
 
 	return cmd
 }
+
+// shellQuote wraps the value in single quotes, which prevents the shell
+// from interpreting it. Single quotes within the value are escaped.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,158 @@
+package sshx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"text/template"
+)
+
+const (
+	// CNIConfigDir is the directory containing the CNI configuration.
+	CNIConfigDir = "/etc/cni/net.d"
+)
+
+// cniDefaults are the default options of all CNI templates.
+var cniDefaults = map[string]string{
+	"cidr": "10.42.0.0/16",
+	"mtu":  "1450",
+}
+
+// cniTemplates contains the configuration templates of the supported CNIs.
+var cniTemplates = map[string]*template.Template{
+	"flannel": cniTemplate(`{
+  "name": "cbr0",
+  "cniVersion": "1.0.0",
+  "plugins": [
+    {
+      "type": "flannel",
+      "delegate": {
+        "hairpinMode": true,
+        "forceAddress": true,
+        "isDefaultGateway": true,
+        "mtu": {{ .mtu }}
+      }
+    },
+    {
+      "type": "portmap",
+      "capabilities": {
+        "portMappings": true
+      }
+    }
+  ]
+}
+`),
+	"calico": cniTemplate(`{
+  "name": "k8s-pod-network",
+  "cniVersion": "0.3.1",
+  "plugins": [
+    {
+      "type": "calico",
+      "datastore_type": "kubernetes",
+      "mtu": {{ .mtu }},
+      "ipam": {
+        "type": "calico-ipam",
+        "ipv4_pools": [{{ json .cidr }}]
+      },
+      "policy": {
+        "type": "k8s"
+      },
+      "kubernetes": {
+        "kubeconfig": "/etc/cni/net.d/calico-kubeconfig"
+      }
+    },
+    {
+      "type": "portmap",
+      "snat": true,
+      "capabilities": {
+        "portMappings": true
+      }
+    }
+  ]
+}
+`),
+	"cilium": cniTemplate(`{
+  "name": "cilium",
+  "cniVersion": "0.3.1",
+  "plugins": [
+    {
+      "type": "cilium-cni",
+      "mtu": {{ .mtu }}
+    }
+  ]
+}
+`),
+	"bridge": cniTemplate(`{
+  "name": "bridge",
+  "cniVersion": "0.4.0",
+  "plugins": [
+    {
+      "type": "bridge",
+      "bridge": "cni0",
+      "isGateway": true,
+      "ipMasq": true,
+      "mtu": {{ .mtu }},
+      "ipam": {
+        "type": "host-local",
+        "ranges": [[{"subnet": {{ json .cidr }}}]],
+        "routes": [{"dst": "0.0.0.0/0"}]
+      }
+    },
+    {
+      "type": "portmap",
+      "capabilities": {
+        "portMappings": true
+      }
+    }
+  ]
+}
+`),
+}
+
+// cniTemplate parses a CNI configuration template.
+func cniTemplate(text string) *template.Template {
+	return template.Must(template.New("cni").Funcs(templateFuncs).Parse(text))
+}
+
+// templateFuncs are the functions available in configuration templates.
+// The "json" function quotes a string, which is also valid in YAML.
+var templateFuncs = template.FuncMap{
+	"json": func(value string) (string, error) {
+		quoted, err := json.Marshal(value)
+		return string(quoted), err
+	},
+}
+
+// ConfigureCNI writes the configuration of a CNI, such as "flannel", "calico",
+// "cilium" or "bridge", to the CNI configuration directory. The options allow
+// to override the "cidr" and "mtu" of the template. It returns
+// ErrUnsupportedCNI if there is no template for the CNI.
+func (client *Client) ConfigureCNI(cniName string, options map[string]string) error {
+	tmpl, ok := cniTemplates[cniName]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnsupportedCNI, cniName)
+	}
+
+	values := make(map[string]string)
+	for key, value := range cniDefaults {
+		values[key] = value
+	}
+	for key, value := range options {
+		values[key] = value
+	}
+
+	// The MTU is rendered as a number and must be validated.
+	if _, err := strconv.Atoi(values["mtu"]); err != nil {
+		return fmt.Errorf("invalid cni mtu: %s", values["mtu"])
+	}
+
+	config := new(bytes.Buffer)
+	if err := tmpl.Execute(config, values); err != nil {
+		return err
+	}
+
+	client.Logger.Info().Str("cni", cniName).Msg("Configuring CNI")
+	return client.AtomicWriteFile(path.Join(CNIConfigDir, fmt.Sprintf("10-%s.conflist", cniName)), config.Bytes(), 0644)
+}
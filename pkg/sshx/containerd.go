@@ -0,0 +1,180 @@
+package sshx
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ContainerdInfo describes the embedded containerd of k3s.
+type ContainerdInfo struct {
+	// Version is the version of the containerd server.
+	Version string
+	// Namespaces are the existing containerd namespaces,
+	// such as "k8s.io", which is used by the kubelet.
+	Namespaces []string
+}
+
+// GetContainerdInfo returns the version and the namespaces of the embedded
+// containerd of k3s. It fails if containerd is not running.
+func (client *Client) GetContainerdInfo() (*ContainerdInfo, error) {
+	if err := client.ensureK3SInstalled(); err != nil {
+		return nil, err
+	}
+
+	version, err := client.output(Cmd{
+		Cmd: "sudo k3s ctr version",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	info := new(ContainerdInfo)
+
+	// The version of the client is printed before the version of the server.
+	scanner := bufio.NewScanner(strings.NewReader(version))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if found && strings.TrimSpace(key) == "Version" {
+			info.Version = strings.TrimSpace(value)
+		}
+	}
+
+	namespaces, err := client.output(Cmd{
+		Cmd: "sudo k3s ctr namespaces ls -q",
+	})
+	if err != nil {
+		return nil, err
+	}
+	info.Namespaces = strings.Fields(namespaces)
+
+	return info, nil
+}
+
+// PullImage pulls a container image into the "k8s.io" namespace of the
+// embedded containerd of k3s, which allows to pre-seed images before a
+// workload is scheduled. The pull is aborted after the configured image
+// pull timeout. It returns ErrImagePullFailed if the pull fails.
+func (client *Client) PullImage(image string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), client.ImagePullTimeout)
+	defer cancel()
+
+	client.Logger.Info().Str("image", image).Msg("Pulling image")
+
+	stderr := new(bytes.Buffer)
+	if err := client.DoContext(ctx, Cmd{
+		Cmd:    "sudo k3s ctr --namespace k8s.io images pull " + image,
+		Stderr: stderr,
+	}); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s: %s", ErrImagePullFailed, image, msg)
+		}
+		return fmt.Errorf("%w: %s: %v", ErrImagePullFailed, image, err)
+	}
+
+	return nil
+}
+
+// ContainerdImage is a container image stored in containerd.
+type ContainerdImage struct {
+	// Reference is the reference of the image, such as
+	// "docker.io/rancher/mirrored-pause:3.6".
+	Reference string
+	// Digest is the digest of the image manifest.
+	Digest string
+	// Size is the human-readable size of the image, such as "301.2 KiB".
+	Size string
+}
+
+// ListImages returns the container images in the "k8s.io" namespace of the
+// embedded containerd of k3s. This allows to verify that airgap images
+// have been imported before starting workloads.
+func (client *Client) ListImages() ([]ContainerdImage, error) {
+	output, err := client.output(Cmd{
+		Cmd: "sudo k3s ctr --namespace k8s.io images ls",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var images []ContainerdImage
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		// The columns are: REF TYPE DIGEST SIZE PLATFORMS LABELS.
+		// The size consists of a value and a unit, such as "1.2 MiB".
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[0] == "REF" {
+			continue
+		}
+
+		images = append(images, ContainerdImage{
+			Reference: fields[0],
+			Digest:    fields[2],
+			Size:      fields[3] + " " + fields[4],
+		})
+	}
+
+	return images, nil
+}
+
+// ImportImageArchive uploads a local image archive, such as the airgap
+// images of k3s, and imports it into the "k8s.io" namespace of the
+// embedded containerd of k3s. It returns the number of imported images.
+// The import is aborted after the configured image pull timeout.
+func (client *Client) ImportImageArchive(localTarPath string) (int, error) {
+	archive, err := os.Open(localTarPath)
+	if err != nil {
+		return 0, err
+	}
+	defer archive.Close()
+
+	dir, cleanup, err := client.TempDir("k3se-images-")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := cleanup(); err != nil {
+			client.Logger.Warn().Err(err).Str("path", dir).Msg("Failed to remove image archive")
+		}
+	}()
+
+	remotePath := path.Join(dir, filepath.Base(localTarPath))
+	client.Logger.Info().Str("archive", localTarPath).Msg("Uploading image archive")
+	if err := client.UploadStream(archive, remotePath); err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), client.ImagePullTimeout)
+	defer cancel()
+
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	if err := client.DoContext(ctx, Cmd{
+		Cmd:    "sudo k3s ctr --namespace k8s.io images import " + remotePath,
+		Stdout: stdout,
+		Stderr: stderr,
+	}); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return 0, fmt.Errorf("%w: %s", err, msg)
+		}
+		return 0, err
+	}
+
+	// Every imported image is reported as "unpacking <ref> ...".
+	images := 0
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "unpacking ") {
+			images++
+		}
+	}
+
+	client.Logger.Info().Int("images", images).Msg("Imported image archive")
+	return images, nil
+}
@@ -0,0 +1,100 @@
+package sshx
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// redacted replaces secrets in serialized configs.
+	redacted = "<redacted>"
+	// redactedChanged replaces secrets that have been changed.
+	redactedChanged = "<redacted, changed>"
+)
+
+// DiffYAML returns a unified diff of the YAML representations of the
+// config and the other config, which allows to document changes in
+// audit logs. Passwords, keys and passphrases are redacted, but changes
+// of them are still reported. An empty string is returned if the
+// configs are equal.
+func (config *Config) DiffYAML(other *Config) (string, error) {
+	from, to := *config, *other
+
+	fromSecrets := []*string{&from.Password, &from.Key, &from.Passphrase}
+	toSecrets := []*string{&to.Password, &to.Key, &to.Passphrase}
+	for i := range fromSecrets {
+		changed := *fromSecrets[i] != *toSecrets[i]
+
+		if *fromSecrets[i] != "" {
+			*fromSecrets[i] = redacted
+		}
+		if *toSecrets[i] != "" {
+			*toSecrets[i] = redacted
+			if changed {
+				*toSecrets[i] = redactedChanged
+			}
+		}
+	}
+
+	before, err := yaml.Marshal(&from)
+	if err != nil {
+		return "", err
+	}
+
+	after, err := yaml.Marshal(&to)
+	if err != nil {
+		return "", err
+	}
+
+	if string(before) == string(after) {
+		return "", nil
+	}
+
+	return unifiedDiff("a/config.yaml", "b/config.yaml", string(before), string(after)), nil
+}
+
+// unifiedDiff computes a line-based diff of two texts based on their
+// longest common subsequence. The diff is rendered as a single hunk
+// with the full context, which is sufficient for small documents.
+func unifiedDiff(fromName, toName, from, to string) string {
+	a := strings.Split(strings.TrimSuffix(from, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(to, "\n"), "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	diff := new(strings.Builder)
+	fmt.Fprintf(diff, "--- %s\n+++ %s\n@@ -1,%d +1,%d @@\n", fromName, toName, len(a), len(b))
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			fmt.Fprintf(diff, " %s\n", a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(diff, "-%s\n", a[i])
+			i++
+		default:
+			fmt.Fprintf(diff, "+%s\n", b[j])
+			j++
+		}
+	}
+
+	return diff.String()
+}
@@ -0,0 +1,118 @@
+package sshx
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"sync"
+)
+
+const (
+	// maxDiscoveryHosts limits the size of the networks that may be scanned.
+	maxDiscoveryHosts = 1 << 16
+)
+
+// DiscoverK3SNodes scans all addresses of a network in CIDR notation for
+// hosts running k3s. It attempts to connect to every address using the SSH
+// config and returns connected clients for the hosts where k3s is detected.
+// The number of concurrent connection attempts is limited by the Concurrency
+// option. The caller is responsible for closing the returned clients.
+func DiscoverK3SNodes(network string, sshConfig *Config, options ...Option) ([]*Client, error) {
+	opts, err := GetDefaultOptions().Apply(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts, err := networkHosts(network)
+	if err != nil {
+		return nil, err
+	}
+
+	// discovered is a client with the address of its host.
+	type discovered struct {
+		host   netip.Addr
+		client *Client
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mutex     sync.Mutex
+		nodes     []discovered
+		semaphore = make(chan struct{}, opts.Concurrency)
+	)
+
+	for _, host := range hosts {
+		wg.Add(1)
+		semaphore <- struct{}{}
+
+		go func(host netip.Addr) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			config := *sshConfig
+			config.Host = host.String()
+
+			client, err := NewClient(&config, options...)
+			if err != nil {
+				return
+			}
+
+			version, err := client.GetK3SVersion()
+			if err != nil {
+				client.Close()
+				return
+			}
+
+			opts.Logger.Info().Str("host", config.Host).Str("version", version).Msg("Discovered k3s node")
+
+			mutex.Lock()
+			nodes = append(nodes, discovered{host: host, client: client})
+			mutex.Unlock()
+		}(host)
+	}
+
+	wg.Wait()
+
+	// Sort the clients to return them in a stable order.
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].host.Less(nodes[j].host)
+	})
+
+	clients := make([]*Client, 0, len(nodes))
+	for _, node := range nodes {
+		clients = append(clients, node.client)
+	}
+
+	return clients, nil
+}
+
+// networkHosts returns all host addresses of a network. For IPv4 networks
+// the network and broadcast addresses are omitted.
+func networkHosts(network string) ([]netip.Addr, error) {
+	prefix, err := netip.ParsePrefix(network)
+	if err != nil {
+		return nil, err
+	}
+	prefix = prefix.Masked()
+
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits > 16 {
+		return nil, fmt.Errorf("network %s exceeds %d hosts", network, maxDiscoveryHosts)
+	}
+
+	var hosts []netip.Addr
+	for addr := prefix.Addr(); prefix.Contains(addr); addr = addr.Next() {
+		hosts = append(hosts, addr)
+	}
+
+	if prefix.Addr().Is4() && hostBits > 1 {
+		hosts = hosts[1 : len(hosts)-1]
+	}
+
+	if len(hosts) == 0 {
+		return nil, errors.New("network contains no hosts")
+	}
+
+	return hosts, nil
+}
@@ -0,0 +1,62 @@
+package sshx
+
+import (
+	"strings"
+)
+
+// dockerComposePackages are the package names of Docker Compose.
+var dockerComposePackages = []string{"docker-compose", "docker-compose-plugin"}
+
+// dockerComposeBinaries are the locations of manually installed
+// Docker Compose binaries and Docker CLI plugins.
+var dockerComposeBinaries = []string{
+	"/usr/local/bin/docker-compose",
+	"/usr/local/lib/docker/cli-plugins/docker-compose",
+	"/usr/libexec/docker/cli-plugins/docker-compose",
+	"/usr/lib/docker/cli-plugins/docker-compose",
+}
+
+// EnsureDockerComposeRemoved removes the standalone "docker-compose" and
+// the "docker compose" plugin, which may conflict with the networking
+// of the containerd embedded in k3s. Packages are removed via the
+// package manager and manually installed binaries are deleted.
+func (client *Client) EnsureDockerComposeRemoved() error {
+	_, standaloneErr := client.output(Cmd{
+		Cmd: "command -v docker-compose",
+	})
+	_, pluginErr := client.output(Cmd{
+		Cmd: "docker compose version",
+	})
+	if standaloneErr != nil && pluginErr != nil {
+		client.Logger.Info().Msg("Docker Compose is not installed")
+		return nil
+	}
+
+	manager, err := client.packageManager()
+	if err != nil {
+		return err
+	}
+
+	var installed []string
+	for _, pkg := range dockerComposePackages {
+		if err := client.Do(Cmd{
+			Cmd: manager.query + " " + pkg,
+		}); err == nil {
+			installed = append(installed, pkg)
+		}
+	}
+
+	if len(installed) > 0 {
+		client.Logger.Info().Strs("packages", installed).Msg("Removing Docker Compose packages")
+		if err := client.Do(Cmd{
+			Cmd: manager.remove + " " + strings.Join(installed, " "),
+		}); err != nil {
+			return err
+		}
+	}
+
+	client.Logger.Info().Msg("Removing Docker Compose binaries")
+	return client.Do(Cmd{
+		Cmd: "sudo rm -f " + strings.Join(dockerComposeBinaries, " "),
+	})
+}
@@ -0,0 +1,43 @@
+package sshx
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ToEnv returns the config as environment variables, such as "PREFIX_HOST",
+// which may be passed to a child process or stored in a ".env" file. The
+// password, key and passphrase are redacted by omitting them.
+func (config *Config) ToEnv(prefix string) map[string]string {
+	if prefix != "" {
+		prefix = strings.ToUpper(prefix) + "_"
+	}
+
+	env := map[string]string{
+		"HOST":                config.Host,
+		"USER":                config.User,
+		"KEY_FILE":            config.KeyFile,
+		"FINGERPRINT":         config.Fingerprint,
+		"HOST_KEY_ALGORITHMS": strings.Join(config.HostKeyAlgorithms, ","),
+		"KEY_EXCHANGES":       strings.Join(config.KeyExchanges, ","),
+		"CIPHERS":             strings.Join(config.Ciphers, ","),
+		"MACS":                strings.Join(config.MACs, ","),
+		"KNOWN_HOSTS_FILE":    config.KnownHostsFile,
+	}
+	if config.Port != 0 {
+		env["PORT"] = strconv.Itoa(config.Port)
+	}
+	if config.Timeout != 0 {
+		env["TIMEOUT"] = config.Timeout.String()
+	}
+
+	prefixed := make(map[string]string, len(env))
+	for key, value := range env {
+		// Omit unset values to avoid overriding defaults.
+		if value != "" {
+			prefixed[prefix+key] = value
+		}
+	}
+
+	return prefixed
+}
@@ -0,0 +1,110 @@
+package sshx
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrRebootRequired indicates that a change only takes
+	// effect after the remote host has been rebooted.
+	ErrRebootRequired = errors.New("reboot required")
+	// ErrSFTPDisabled indicates that an operation requires
+	// SFTP, but the SFTP client has been disabled.
+	ErrSFTPDisabled = errors.New("sftp disabled")
+	// ErrK3SNotInstalled indicates that k3s is
+	// not installed on the remote host.
+	ErrK3SNotInstalled = errors.New("k3s not installed")
+	// ErrK3SNotServer indicates that an operation is only
+	// supported on k3s servers, but the node is an agent.
+	ErrK3SNotServer = errors.New("k3s node is not a server")
+	// ErrNotEtcdNode indicates that the node is not
+	// a member of the embedded etcd cluster of k3s.
+	ErrNotEtcdNode = errors.New("node is not an etcd member")
+	// ErrEtcdSnapshotFailed indicates that an etcd snapshot could not be created.
+	ErrEtcdSnapshotFailed = errors.New("etcd snapshot failed")
+	// ErrUnsupportedArch indicates that a CPU architecture
+	// is not supported by the k3s release artifacts.
+	ErrUnsupportedArch = errors.New("unsupported architecture")
+	// ErrCertExpiringSoon indicates that a certificate
+	// expires within the configured threshold.
+	ErrCertExpiringSoon = errors.New("certificate expiring soon")
+	// ErrUnsupportedCNI indicates that there is no
+	// configuration template for the requested CNI.
+	ErrUnsupportedCNI = errors.New("unsupported cni")
+	// ErrUnsupportedDNSProvider indicates that
+	// ExternalDNS does not support the DNS provider.
+	ErrUnsupportedDNSProvider = errors.New("unsupported dns provider")
+	// ErrImagePullFailed indicates that a container image could not be pulled.
+	ErrImagePullFailed = errors.New("image pull failed")
+	// ErrK3SReadyTimeout indicates that the API server of
+	// k3s did not become ready within the timeout.
+	ErrK3SReadyTimeout = errors.New("k3s not ready")
+	// ErrRestartRequired indicates that a change only takes
+	// effect after k3s has been restarted.
+	ErrRestartRequired = errors.New("restart required")
+	// ErrUnknownFeatureGate indicates that a feature
+	// gate is not known to the Kubernetes API server.
+	ErrUnknownFeatureGate = errors.New("unknown feature gate")
+	// ErrClusterTooSmall indicates that an operation would
+	// leave the cluster with too few members to tolerate failures.
+	ErrClusterTooSmall = errors.New("cluster too small")
+	// ErrPollTimeout indicates that a condition
+	// was not met before the timeout expired.
+	ErrPollTimeout = errors.New("poll timeout")
+	// ErrUnknownPodSecurityProfile indicates that a profile
+	// is not one of the Pod Security Standards.
+	ErrUnknownPodSecurityProfile = errors.New("unknown pod security profile")
+)
+
+// ErrChecksumMismatch indicates that the checksum of
+// a remote file does not match the expected checksum.
+type ErrChecksumMismatch struct {
+	Path     string
+	Expected string
+	Actual   string
+}
+
+// Error returns a human-readable description of the mismatch.
+func (e ErrChecksumMismatch) Error() string {
+	return fmt.Sprintf("checksum mismatch: %s: expected %s, got %s", e.Path, e.Expected, e.Actual)
+}
+
+// MultiError collects the errors of operations that
+// run on multiple nodes, such as concurrent installs.
+type MultiError []error
+
+// Error returns the messages of all errors prefixed with their index.
+func (e MultiError) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = fmt.Sprintf("[%d] %v", i, err)
+	}
+
+	return fmt.Sprintf("%d errors occurred: %s", len(e), strings.Join(messages, "; "))
+}
+
+// Unwrap returns the collected errors, which allows
+// to inspect them via errors.Is and errors.As.
+func (e MultiError) Unwrap() []error {
+	return e
+}
+
+// First returns the first collected error or nil if there is none.
+func (e MultiError) First() error {
+	if len(e) == 0 {
+		return nil
+	}
+
+	return e[0]
+}
+
+// HasErrors reports whether any errors have been collected.
+func (e MultiError) HasErrors() bool {
+	return len(e) > 0
+}
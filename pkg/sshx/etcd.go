@@ -0,0 +1,289 @@
+package sshx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// K3SEtcdDataDir is the data directory of the embedded etcd of k3s.
+	K3SEtcdDataDir = "/var/lib/rancher/k3s/server/db/etcd"
+	// K3SEtcdSnapshotDir is the default directory of the etcd snapshots.
+	K3SEtcdSnapshotDir = "/var/lib/rancher/k3s/server/db/snapshots"
+	// k3sEtcdTLSDir is the directory containing the etcd client certificates.
+	k3sEtcdTLSDir = "/var/lib/rancher/k3s/server/tls/etcd"
+	// etcdSnapshotTimeout is the maximum time to create an etcd snapshot.
+	etcdSnapshotTimeout = time.Minute * 5
+	// etcdSnapshotListTimeout is the maximum time for a new
+	// snapshot to appear in the list of snapshots.
+	etcdSnapshotListTimeout = time.Second * 30
+	// etcdRestoreTimeout is the maximum time to restore an etcd snapshot.
+	etcdRestoreTimeout = time.Minute * 10
+)
+
+// EtcdStatus describes the state of the embedded etcd member of a node.
+type EtcdStatus struct {
+	// Leader is true if the member is the leader of the etcd cluster.
+	Leader bool
+	// DbSizeBytes is the size of the etcd database.
+	DbSizeBytes int64
+	// SnapshotCount is the number of available etcd snapshots.
+	SnapshotCount int
+}
+
+// EtcdSnapshot describes an etcd snapshot as listed by k3s.
+type EtcdSnapshot struct {
+	Name     string
+	Location string
+	Size     int64
+	Created  time.Time
+}
+
+// etcdEndpointStatus is the JSON output of "etcdctl endpoint status".
+type etcdEndpointStatus struct {
+	Endpoint string `json:"Endpoint"`
+	Status   struct {
+		Header struct {
+			MemberID uint64 `json:"member_id"`
+		} `json:"header"`
+		Leader uint64 `json:"leader"`
+		DbSize int64  `json:"dbSize"`
+	} `json:"Status"`
+}
+
+// GetEtcdStatus returns the state of the embedded etcd member of the node.
+// It returns ErrNotEtcdNode if the node does not run the embedded etcd.
+func (client *Client) GetEtcdStatus() (*EtcdStatus, error) {
+	if err := client.ensureEtcdNode(); err != nil {
+		return nil, err
+	}
+
+	output, err := client.etcdctl("endpoint status -w json")
+	if err != nil {
+		return nil, err
+	}
+
+	var endpoints []etcdEndpointStatus
+	if err := json.Unmarshal([]byte(output), &endpoints); err != nil {
+		return nil, err
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no etcd endpoint status found")
+	}
+
+	snapshots, err := client.ListEtcdSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := endpoints[0]
+	return &EtcdStatus{
+		Leader:        endpoint.Status.Leader == endpoint.Status.Header.MemberID,
+		DbSizeBytes:   endpoint.Status.DbSize,
+		SnapshotCount: len(snapshots),
+	}, nil
+}
+
+// CreateEtcdSnapshot creates a snapshot of the embedded etcd with the
+// specified name. Please note that k3s appends the node name and a
+// timestamp to the name. It returns ErrEtcdSnapshotFailed if the
+// snapshot could not be created or is not listed afterwards.
+func (client *Client) CreateEtcdSnapshot(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdSnapshotTimeout)
+	defer cancel()
+
+	// The streams are copied concurrently, which is why
+	// they must not share the same buffer.
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+
+	client.Logger.Info().Str("snapshot", name).Msg("Creating etcd snapshot")
+	if err := client.DoContext(ctx, Cmd{
+		Cmd:    "sudo k3s etcd-snapshot save --name " + name,
+		Stdout: stdout,
+		Stderr: stderr,
+	}); err != nil {
+		return fmt.Errorf("%w: %v: %s", ErrEtcdSnapshotFailed, err, strings.TrimSpace(stderr.String()+stdout.String()))
+	}
+
+	if err := client.PollUntil(func(client *Client) (bool, error) {
+		snapshots, err := client.ListEtcdSnapshots()
+		if err != nil {
+			return false, Permanent(err)
+		}
+
+		for _, snapshot := range snapshots {
+			if strings.HasPrefix(snapshot.Name, name) {
+				return true, nil
+			}
+		}
+
+		return false, fmt.Errorf("snapshot not listed: %s", strings.TrimSpace(stderr.String()+stdout.String()))
+	}, time.Second, etcdSnapshotListTimeout); err != nil {
+		return fmt.Errorf("%w: %w", ErrEtcdSnapshotFailed, err)
+	}
+
+	return nil
+}
+
+// ExportEtcdSnapshot creates a new etcd snapshot and downloads it to the
+// local path, which allows to store backups outside of the cluster. The
+// remote snapshot is deleted afterwards unless KeepRemote is set.
+func (client *Client) ExportEtcdSnapshot(localPath string) error {
+	if err := client.ensureEtcdNode(); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("k3se-export-%d", time.Now().Unix())
+	if err := client.CreateEtcdSnapshot(name); err != nil {
+		return err
+	}
+
+	snapshots, err := client.ListEtcdSnapshots()
+	if err != nil {
+		return err
+	}
+
+	var snapshot *EtcdSnapshot
+	for i := range snapshots {
+		if strings.HasPrefix(snapshots[i].Name, name) {
+			snapshot = &snapshots[i]
+		}
+	}
+	if snapshot == nil {
+		return fmt.Errorf("%w: snapshot not listed: %s", ErrEtcdSnapshotFailed, name)
+	}
+
+	// Snapshots uploaded to S3 are not stored locally.
+	remotePath, found := strings.CutPrefix(snapshot.Location, "file://")
+	if !found {
+		return fmt.Errorf("snapshot is not stored on the node: %s", snapshot.Location)
+	}
+
+	client.Logger.Info().Str("snapshot", snapshot.Name).Str("path", localPath).Msg("Downloading etcd snapshot")
+	if err := client.DownloadFile(remotePath, localPath); err != nil {
+		return err
+	}
+
+	if client.KeepRemote {
+		return nil
+	}
+
+	client.Logger.Info().Str("snapshot", snapshot.Name).Msg("Deleting remote etcd snapshot")
+	return client.Do(Cmd{
+		Cmd: "sudo k3s etcd-snapshot delete " + snapshot.Name,
+	})
+}
+
+// RestoreEtcdSnapshot restores the embedded etcd from a snapshot, which
+// may either be the name of a snapshot in the default snapshot directory
+// or an absolute path. The k3s server is stopped during the restore and
+// the health of etcd is validated once the server has been restarted.
+// If the restore fails, the server is started with its previous data.
+func (client *Client) RestoreEtcdSnapshot(snapshotName string) error {
+	if err := client.ensureK3SServer(); err != nil {
+		return err
+	}
+
+	snapshotPath := snapshotName
+	if !path.IsAbs(snapshotPath) {
+		snapshotPath = path.Join(K3SEtcdSnapshotDir, snapshotName)
+	}
+
+	if err := client.StopK3S(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcdRestoreTimeout)
+	defer cancel()
+
+	// Stream the output of the reset to the logger to follow its progress.
+	// The streams are copied concurrently and therefore need separate writers.
+	client.Logger.Info().Str("snapshot", snapshotPath).Msg("Restoring etcd snapshot")
+	if err := client.DoContext(ctx, Cmd{
+		Cmd:    "sudo k3s server --cluster-reset --cluster-reset-restore-path=" + snapshotPath,
+		Stdout: &logWriter{logger: client.Logger},
+		Stderr: &logWriter{logger: client.Logger},
+	}); err != nil {
+		// Start k3s with its previous data to avoid leaving the node down.
+		if startErr := client.StartK3S(); startErr != nil {
+			return fmt.Errorf("restore failed: %w, k3s left stopped: %w", err, startErr)
+		}
+		return fmt.Errorf("restore failed: %w", err)
+	}
+
+	if err := client.StartK3S(); err != nil {
+		return err
+	}
+
+	if err := client.WaitForK3SReady(k3sReadyTimeout); err != nil {
+		return err
+	}
+
+	if _, err := client.GetEtcdStatus(); err != nil {
+		return fmt.Errorf("etcd unhealthy after restore: %w", err)
+	}
+
+	return nil
+}
+
+// ensureEtcdNode returns ErrNotEtcdNode if the node
+// does not run the embedded etcd of k3s.
+func (client *Client) ensureEtcdNode() error {
+	if err := client.Do(Cmd{
+		Cmd: "sudo test -d " + K3SEtcdDataDir,
+	}); err != nil {
+		return ErrNotEtcdNode
+	}
+
+	return nil
+}
+
+// etcdctl runs etcdctl against the local etcd member using the
+// client certificates of k3s and returns the output.
+func (client *Client) etcdctl(args string) (string, error) {
+	return client.output(Cmd{
+		Cmd: fmt.Sprintf(
+			"sudo ETCDCTL_API=3 etcdctl --endpoints https://127.0.0.1:2379 --cacert %[1]s/server-ca.crt --cert %[1]s/server-client.crt --key %[1]s/server-client.key %[2]s",
+			k3sEtcdTLSDir, args,
+		),
+	})
+}
+
+// ListEtcdSnapshots returns the etcd snapshots known to the k3s server.
+func (client *Client) ListEtcdSnapshots() ([]EtcdSnapshot, error) {
+	output, err := client.output(Cmd{
+		Cmd: "sudo k3s etcd-snapshot ls",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []EtcdSnapshot
+	for i, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+
+		// Skip the header and malformed lines.
+		if i == 0 || len(fields) < 4 {
+			continue
+		}
+
+		size, _ := strconv.ParseInt(fields[2], 10, 64)
+		created, _ := time.Parse(time.RFC3339, fields[3])
+
+		snapshots = append(snapshots, EtcdSnapshot{
+			Name:     fields[0],
+			Location: fields[1],
+			Size:     size,
+			Created:  created,
+		})
+	}
+
+	return snapshots, nil
+}
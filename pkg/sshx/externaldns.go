@@ -0,0 +1,143 @@
+package sshx
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+const (
+	// ExternalDNSImage is the container image of ExternalDNS.
+	ExternalDNSImage = "registry.k8s.io/external-dns/external-dns:v0.15.0"
+)
+
+// externalDNSProvider describes how the credentials of a
+// DNS provider are passed to ExternalDNS.
+type externalDNSProvider struct {
+	name      string
+	accessEnv string
+	secretEnv string
+}
+
+// externalDNSProviders are the supported DNS providers. Providers
+// authenticating with a single token only use the secret key.
+var externalDNSProviders = map[string]externalDNSProvider{
+	"aws":          {name: "aws", accessEnv: "AWS_ACCESS_KEY_ID", secretEnv: "AWS_SECRET_ACCESS_KEY"},
+	"route53":      {name: "aws", accessEnv: "AWS_ACCESS_KEY_ID", secretEnv: "AWS_SECRET_ACCESS_KEY"},
+	"cloudflare":   {name: "cloudflare", accessEnv: "CF_API_EMAIL", secretEnv: "CF_API_KEY"},
+	"digitalocean": {name: "digitalocean", secretEnv: "DO_TOKEN"},
+	"linode":       {name: "linode", secretEnv: "LINODE_TOKEN"},
+}
+
+// externalDNSTemplate renders the manifest of ExternalDNS.
+var externalDNSTemplate = template.Must(template.New("external-dns").Funcs(templateFuncs).Parse(`apiVersion: v1
+kind: Namespace
+metadata:
+  name: external-dns
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: external-dns
+  namespace: external-dns
+type: Opaque
+stringData:
+{{- if .AccessEnv }}
+  {{ .AccessEnv }}: {{ json .AccessKey }}
+{{- end }}
+  {{ .SecretEnv }}: {{ json .SecretKey }}
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: external-dns
+  namespace: external-dns
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: external-dns
+rules:
+  - apiGroups: [""]
+    resources: ["services", "endpoints", "pods", "nodes"]
+    verbs: ["get", "watch", "list"]
+  - apiGroups: ["extensions", "networking.k8s.io"]
+    resources: ["ingresses"]
+    verbs: ["get", "watch", "list"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: external-dns
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: external-dns
+subjects:
+  - kind: ServiceAccount
+    name: external-dns
+    namespace: external-dns
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: external-dns
+  namespace: external-dns
+spec:
+  replicas: 1
+  strategy:
+    type: Recreate
+  selector:
+    matchLabels:
+      app: external-dns
+  template:
+    metadata:
+      labels:
+        app: external-dns
+    spec:
+      serviceAccountName: external-dns
+      containers:
+        - name: external-dns
+          image: {{ .Image }}
+          args:
+            - --source=service
+            - --source=ingress
+            - --provider={{ .Provider }}
+            - {{ json (printf "--domain-filter=%s" .DomainFilter) }}
+            - --registry=txt
+            - --txt-owner-id=k3se
+          envFrom:
+            - secretRef:
+                name: external-dns
+`))
+
+// ConfigureExternalDNS deploys ExternalDNS for a DNS provider, such as
+// "aws" or "cloudflare", which manages the DNS records of services and
+// ingresses within the domain filter. The credentials are stored in a
+// secret. It returns ErrUnsupportedDNSProvider for unknown providers.
+func (client *Client) ConfigureExternalDNS(provider, domainFilter, accessKey, secretKey string) error {
+	settings, ok := externalDNSProviders[provider]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnsupportedDNSProvider, provider)
+	}
+
+	manifest := new(bytes.Buffer)
+	if err := externalDNSTemplate.Execute(manifest, map[string]string{
+		"Image":        ExternalDNSImage,
+		"Provider":     settings.name,
+		"DomainFilter": domainFilter,
+		"AccessEnv":    settings.accessEnv,
+		"AccessKey":    accessKey,
+		"SecretEnv":    settings.secretEnv,
+		"SecretKey":    secretKey,
+	}); err != nil {
+		return err
+	}
+
+	client.Logger.Info().Str("provider", settings.name).Str("domain", domainFilter).Msg("Configuring ExternalDNS")
+	if err := client.ApplyManifest(manifest.Bytes()); err != nil {
+		return err
+	}
+
+	return client.WaitForDeployment("external-dns", "external-dns", addonReadyTimeout)
+}
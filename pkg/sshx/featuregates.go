@@ -0,0 +1,144 @@
+package sshx
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// featureGates are the known feature gates of the Kubernetes API server.
+var featureGates = map[string]bool{
+	"AdmissionWebhookMatchConditions":          true,
+	"AggregatedDiscoveryEndpoint":              true,
+	"AllAlpha":                                 true,
+	"AllBeta":                                  true,
+	"AllowServiceLBStatusOnNonLB":              true,
+	"AnonymousAuthConfigurableEndpoints":       true,
+	"APIResponseCompression":                   true,
+	"APIServerTracing":                         true,
+	"AuthorizeNodeWithSelectors":               true,
+	"AuthorizeWithSelectors":                   true,
+	"CloudControllerManagerWebhook":            true,
+	"ClusterTrustBundle":                       true,
+	"ClusterTrustBundleProjection":             true,
+	"ComponentSLIs":                            true,
+	"ConcurrentWatchObjectDecode":              true,
+	"ConsistentListFromCache":                  true,
+	"ContainerCheckpoint":                      true,
+	"CoordinatedLeaderElection":                true,
+	"CPUManagerPolicyAlphaOptions":             true,
+	"CPUManagerPolicyBetaOptions":              true,
+	"CRDValidationRatcheting":                  true,
+	"CSIVolumeHealth":                          true,
+	"CustomResourceFieldSelectors":             true,
+	"DRAControlPlaneController":                true,
+	"DynamicResourceAllocation":                true,
+	"ElasticIndexedJob":                        true,
+	"GracefulNodeShutdown":                     true,
+	"GracefulNodeShutdownBasedOnPodPriority":   true,
+	"HonorPVReclaimPolicy":                     true,
+	"HPAScaleToZero":                           true,
+	"ImageVolume":                              true,
+	"InformerResourceVersion":                  true,
+	"InPlacePodVerticalScaling":                true,
+	"InTreePluginPortworxUnregister":           true,
+	"JobManagedBy":                             true,
+	"JobPodReplacementPolicy":                  true,
+	"KMSv1":                                    true,
+	"KubeletCgroupDriverFromCRI":               true,
+	"LegacyServiceAccountTokenCleanUp":         true,
+	"LoadBalancerIPMode":                       true,
+	"MatchLabelKeysInPodAffinity":              true,
+	"MemoryManager":                            true,
+	"MemoryQoS":                                true,
+	"MultiCIDRServiceAllocator":                true,
+	"MutatingAdmissionPolicy":                  true,
+	"NFTablesProxyMode":                        true,
+	"NodeInclusionPolicyInPodTopologySpread":   true,
+	"NodeLogQuery":                             true,
+	"NodeSwap":                                 true,
+	"OpenAPIEnums":                             true,
+	"PersistentVolumeLastPhaseTransitionTime":  true,
+	"PodDisruptionConditions":                  true,
+	"PodIndexLabel":                            true,
+	"PodLifecycleSleepAction":                  true,
+	"PodLifecycleSleepActionAllowZero":         true,
+	"PodSchedulingReadiness":                   true,
+	"PortForwardWebsockets":                    true,
+	"ProcMountType":                            true,
+	"RecoverVolumeExpansionFailure":            true,
+	"RecursiveReadOnlyMounts":                  true,
+	"RelaxedEnvironmentVariableValidation":     true,
+	"ResilientWatchCacheInitialization":        true,
+	"RetryGenerateName":                        true,
+	"RuntimeClassInImageCriApi":                true,
+	"SchedulerQueueingHints":                   true,
+	"SELinuxMount":                             true,
+	"SeparateCacheWatchRPC":                    true,
+	"ServiceAccountTokenJTI":                   true,
+	"ServiceAccountTokenNodeBinding":           true,
+	"ServiceAccountTokenNodeBindingValidation": true,
+	"ServiceAccountTokenPodNodeInfo":           true,
+	"ServiceTrafficDistribution":               true,
+	"SidecarContainers":                        true,
+	"SizeMemoryBackedVolumes":                  true,
+	"StatefulSetAutoDeletePVC":                 true,
+	"StorageVersionAPI":                        true,
+	"StorageVersionMigrator":                   true,
+	"StructuredAuthenticationConfiguration":    true,
+	"StructuredAuthorizationConfiguration":     true,
+	"TopologyManagerPolicyAlphaOptions":        true,
+	"TopologyManagerPolicyBetaOptions":         true,
+	"TopologyManagerPolicyOptions":             true,
+	"TranslateStreamCloseWebsocketRequests":    true,
+	"UnknownVersionInteroperabilityProxy":      true,
+	"UserNamespacesSupport":                    true,
+	"ValidatingAdmissionPolicy":                true,
+	"VolumeAttributesClass":                    true,
+	"WatchList":                                true,
+}
+
+// EnableFeatureGate enables a feature gate of the Kubernetes API server,
+// such as "SidecarContainers", by adding it to the "feature-gates" in the
+// "kube-apiserver-arg" of the k3s configuration. K3s is restarted and the
+// function blocks until k3s is ready again. It returns
+// ErrUnknownFeatureGate if the feature gate is not known.
+func (client *Client) EnableFeatureGate(gate string) error {
+	if !featureGates[gate] {
+		return fmt.Errorf("%w: %s", ErrUnknownFeatureGate, gate)
+	}
+
+	if err := client.ensureK3SServer(); err != nil {
+		return err
+	}
+
+	config, err := client.readK3SConfigMap()
+	if err != nil {
+		return err
+	}
+
+	gates := map[string]string{gate: "true"}
+	for _, arg := range k3sAPIServerArgs(config) {
+		existing, found := strings.CutPrefix(arg, "feature-gates=")
+		if !found {
+			continue
+		}
+
+		for _, pair := range strings.Split(existing, ",") {
+			if name, value, ok := strings.Cut(pair, "="); ok && name != gate {
+				gates[name] = value
+			}
+		}
+	}
+
+	pairs := make([]string, 0, len(gates))
+	for name, value := range gates {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+
+	client.Logger.Info().Str("gate", gate).Msg("Enabling feature gate")
+	return client.setK3SAPIServerArgs(map[string]string{
+		"feature-gates": strings.Join(pairs, ","),
+	})
+}
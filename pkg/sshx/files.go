@@ -0,0 +1,483 @@
+package sshx
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReadFile reads the content of a remote file. The file is read via
+// SFTP if possible. If SFTP is disabled or the SSH user lacks the
+// permissions to read the file, the content is read via "sudo cat".
+// Files of virtual filesystems, such as "/proc", are never cached.
+func (client *Client) ReadFile(remotePath string) ([]byte, error) {
+	cacheable := client.fileCache != nil && !isVirtualFile(remotePath)
+
+	if cacheable {
+		if content, ok := client.fileCache.get(remotePath); ok {
+			return content, nil
+		}
+	}
+
+	content, err := client.readFile(remotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if cacheable {
+		client.fileCache.set(remotePath, content)
+	}
+
+	return content, nil
+}
+
+// isVirtualFile reports whether the file is provided by the kernel
+// and therefore changes its content without being written to.
+func isVirtualFile(remotePath string) bool {
+	return strings.HasPrefix(remotePath, "/proc/") || strings.HasPrefix(remotePath, "/sys/")
+}
+
+// readFile reads the content of a remote file without using the cache.
+func (client *Client) readFile(remotePath string) ([]byte, error) {
+	if client.SFTP != nil {
+		content, err := client.readFileSFTP(remotePath)
+		if err == nil || !errors.Is(err, os.ErrPermission) {
+			return content, err
+		}
+	}
+
+	buffer := new(bytes.Buffer)
+	if err := client.Do(Cmd{
+		Cmd:    "sudo cat " + remotePath,
+		Stdout: buffer,
+	}); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// DownloadFile downloads a remote file to the local path. The file is read
+// via SFTP if possible. If SFTP is disabled or the SSH user lacks the
+// permissions to read the file, the content is read via "sudo cat".
+func (client *Client) DownloadFile(remotePath, localPath string) error {
+	dst, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+
+	if err := client.downloadFile(remotePath, dst); err != nil {
+		dst.Close()
+		os.Remove(localPath)
+		return err
+	}
+
+	return dst.Close()
+}
+
+// downloadFile streams the content of a remote file to the writer.
+func (client *Client) downloadFile(remotePath string, dst io.Writer) error {
+	if client.SFTP != nil {
+		src, err := client.SFTP.Open(remotePath)
+		if err == nil {
+			defer src.Close()
+
+			_, err = io.Copy(dst, src)
+			return err
+		}
+
+		if !errors.Is(err, os.ErrPermission) {
+			return err
+		}
+	}
+
+	return client.Do(Cmd{
+		Cmd:    "sudo cat " + remotePath,
+		Stdout: dst,
+	})
+}
+
+// UploadStream writes the content of the reader to the remote file. Missing
+// parent directories are created and an existing file is overwritten.
+func (client *Client) UploadStream(src io.Reader, remotePath string) error {
+	if client.SFTP == nil {
+		return ErrSFTPDisabled
+	}
+	defer client.invalidateFile(remotePath)
+
+	// Create directory if it does not exist.
+	if err := client.SFTP.MkdirAll(path.Dir(remotePath)); err != nil {
+		return err
+	}
+
+	file, err := client.SFTP.Create(remotePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Restrict permissions.
+	if err := client.SFTP.Chmod(remotePath, 0644); err != nil {
+		return err
+	}
+
+	// Empty existing file.
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+
+	// Overwrite file content.
+	_, err = io.Copy(file, src)
+	return err
+}
+
+// AtomicWriteFile writes data to a remote file with root privileges. The
+// data is first written to a temporary file next to the destination and
+// then moved into place, so readers never observe a partially written
+// file. Missing parent directories are created.
+func (client *Client) AtomicWriteFile(remotePath string, data []byte, mode os.FileMode) error {
+	defer client.invalidateFile(remotePath)
+
+	tmpPath := fmt.Sprintf("%s.k3se-%s", remotePath, randomHex(4))
+
+	return client.Do(Cmd{
+		Cmd: fmt.Sprintf(
+			"sudo mkdir -p %s && sudo tee %s >/dev/null && sudo chmod %o %s && sudo mv -f %s %s",
+			path.Dir(remotePath), tmpPath, mode.Perm(), tmpPath, tmpPath, remotePath,
+		),
+		Stdin: bytes.NewReader(data),
+	})
+}
+
+// UploadFile copies a local file to the remote host.
+func (client *Client) UploadFile(localPath, remotePath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return client.UploadStream(file, remotePath)
+}
+
+// Stat returns the file info of a remote file.
+func (client *Client) Stat(remotePath string) (os.FileInfo, error) {
+	if client.SFTP == nil {
+		return nil, ErrSFTPDisabled
+	}
+
+	return client.SFTP.Stat(remotePath)
+}
+
+// ResumeUpload uploads a local file to the remote host and resumes a previous
+// interrupted upload by only transferring the bytes that are missing on the
+// remote host. This avoids restarting uploads of large files from scratch.
+func (client *Client) ResumeUpload(localPath, remotePath string, mode os.FileMode) error {
+	local, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+
+	localInfo, err := local.Stat()
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	remoteInfo, err := client.Stat(remotePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		offset = remoteInfo.Size()
+	}
+
+	// Start over if the remote file is larger and thus not a partial upload.
+	if offset > localInfo.Size() {
+		offset = 0
+	}
+
+	if err := client.SFTP.MkdirAll(path.Dir(remotePath)); err != nil {
+		return err
+	}
+
+	defer client.invalidateFile(remotePath)
+
+	remote, err := client.SFTP.OpenFile(remotePath, os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+
+	if offset == 0 {
+		if err := remote.Truncate(0); err != nil {
+			return err
+		}
+	} else {
+		client.Logger.Info().Str("path", remotePath).Int64("offset", offset).Msg("Resuming upload")
+	}
+
+	if _, err := local.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := remote.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(remote, local); err != nil {
+		return err
+	}
+
+	if err := client.SFTP.Chmod(remotePath, mode); err != nil {
+		return err
+	}
+
+	// Verify that the upload is complete.
+	if remoteInfo, err = client.Stat(remotePath); err != nil {
+		return err
+	}
+	if remoteInfo.Size() != localInfo.Size() {
+		return fmt.Errorf("incomplete upload: %s: expected %d bytes, got %d", remotePath, localInfo.Size(), remoteInfo.Size())
+	}
+
+	return nil
+}
+
+// readFileSFTP reads the content of a remote file via SFTP.
+func (client *Client) readFileSFTP(remotePath string) ([]byte, error) {
+	file, err := client.SFTP.Open(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
+// BackupDirectory archives a remote directory and writes it to a local
+// tarball named "<basename>-<timestamp>.tar.gz" in the destination
+// directory. The archive is streamed and never buffered in memory.
+func (client *Client) BackupDirectory(remotePath, localDestDir string) error {
+	remotePath = path.Clean(remotePath)
+	base := path.Base(remotePath)
+
+	if err := os.MkdirAll(localDestDir, 0755); err != nil {
+		return err
+	}
+
+	archivePath := filepath.Join(localDestDir, fmt.Sprintf("%s-%s.tar.gz", base, time.Now().Format("20060102150405")))
+	archive, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	client.Logger.Info().Str("path", remotePath).Str("archive", archivePath).Msg("Backing up directory")
+	if err := client.Do(Cmd{
+		Cmd:    fmt.Sprintf("sudo tar czf - -C %s %s", path.Dir(remotePath), base),
+		Stdout: archive,
+	}); err != nil {
+		// Do not leave incomplete archives behind.
+		archive.Close()
+		os.Remove(archivePath)
+		return err
+	}
+
+	return archive.Close()
+}
+
+// TempDir creates a new uniquely named directory in "/tmp" on the remote
+// host, similar to os.MkdirTemp. The returned function removes the
+// directory and its content and should be deferred by the caller.
+func (client *Client) TempDir(prefix string) (string, func() error, error) {
+	if client.SFTP == nil {
+		return "", nil, ErrSFTPDisabled
+	}
+
+	dir := path.Join("/tmp", prefix+randomHex(8))
+	if err := client.SFTP.MkdirAll(dir); err != nil {
+		return "", nil, err
+	}
+
+	cleanup := func() error {
+		return client.RemoveAll(dir)
+	}
+
+	return dir, cleanup, nil
+}
+
+// ListDir returns the names of the entries of a remote directory in
+// lexical order. Directories are suffixed with a slash. If SFTP is
+// disabled or the SSH user lacks the permissions to read the directory,
+// the entries are listed via "sudo ls".
+func (client *Client) ListDir(remotePath string) ([]string, error) {
+	if client.SFTP != nil {
+		entries, err := client.SFTP.ReadDir(remotePath)
+		if err == nil {
+			names := make([]string, 0, len(entries))
+			for _, entry := range entries {
+				name := entry.Name()
+				if entry.IsDir() {
+					name += "/"
+				}
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			return names, nil
+		}
+
+		if !errors.Is(err, os.ErrPermission) {
+			return nil, err
+		}
+	}
+
+	output, err := client.output(Cmd{
+		Cmd: "sudo ls -1Ap " + remotePath,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := strings.Fields(output)
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// RemoveAll removes a remote path and any children it contains.
+func (client *Client) RemoveAll(remotePath string) error {
+	defer client.invalidateFile(remotePath)
+
+	if client.SFTP != nil {
+		return client.SFTP.RemoveAll(remotePath)
+	}
+
+	return client.Do(Cmd{
+		Cmd: "rm -rf " + remotePath,
+	})
+}
+
+// DownloadURL downloads a resource directly on the remote host using curl
+// or wget, which avoids routing large files through the local machine. The
+// "scp://user@host/path" and "sftp://user@host/path" schemes are supported
+// as well to transfer files between nodes using the SSH keys of the remote
+// host. If a checksum is provided, the integrity of the download is verified.
+func (client *Client) DownloadURL(rawURL, remotePath, expectedSHA256 string) error {
+	command := Cmd{
+		Cmd: fmt.Sprintf(
+			`if command -v curl >/dev/null 2>&1; then curl -fsSL -o "%[2]s" "%[1]s"; else wget -q -O "%[2]s" "%[1]s"; fi`,
+			rawURL, remotePath,
+		),
+		Shell: true,
+	}
+
+	if parsed, err := url.Parse(rawURL); err == nil && (parsed.Scheme == "scp" || parsed.Scheme == "sftp") {
+		source := parsed.Hostname() + ":" + parsed.Path
+		if parsed.User != nil {
+			source = parsed.User.Username() + "@" + source
+		}
+
+		port := parsed.Port()
+		if port == "" {
+			port = strconv.Itoa(DefaultPort)
+		}
+
+		// Prompts would block the command as there is no terminal.
+		command = Cmd{
+			Cmd: fmt.Sprintf(
+				`%s -r -P %s -o BatchMode=yes -o StrictHostKeyChecking=accept-new "%s" "%s"`,
+				parsed.Scheme, port, source, remotePath,
+			),
+		}
+	}
+
+	client.Logger.Info().Str("url", rawURL).Str("path", remotePath).Msg("Downloading file")
+	if err := client.Do(command); err != nil {
+		return err
+	}
+
+	if expectedSHA256 != "" {
+		return CheckRemoteFileSHA256(client, remotePath, expectedSHA256)
+	}
+
+	return nil
+}
+
+// FetchSHA256 downloads a checksum file in the format of "sha256sum", such
+// as "sha256sum-amd64.txt" of a k3s release, on the remote host and returns
+// the checksum of the named file.
+func (client *Client) FetchSHA256(checksumURL, fileName string) (string, error) {
+	output, err := client.output(Cmd{
+		Cmd: fmt.Sprintf(
+			`if command -v curl >/dev/null 2>&1; then curl -fsSL "%[1]s"; else wget -q -O - "%[1]s"; fi`,
+			checksumURL,
+		),
+		Shell: true,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Every line has the format "<checksum>  <name>", where
+	// the name is prefixed with an asterisk in binary mode.
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == fileName {
+			return fields[0], nil
+		}
+	}
+
+	return "", fmt.Errorf("no checksum found for %s in %s", fileName, checksumURL)
+}
+
+// CheckRemoteFileSHA256 verifies the integrity of a remote file by comparing
+// its SHA-256 checksum with the expected hex-encoded checksum. If they do
+// not match, an ErrChecksumMismatch is returned.
+func CheckRemoteFileSHA256(client *Client, remotePath string, expected string) error {
+	output, err := client.output(Cmd{
+		Cmd: "sha256sum " + remotePath,
+	})
+	if err != nil {
+		return err
+	}
+
+	// The output has the format "<checksum>  <path>".
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return fmt.Errorf("malformed checksum output: %s", output)
+	}
+
+	actual := strings.ToLower(fields[0])
+	if actual != strings.ToLower(strings.TrimSpace(expected)) {
+		return ErrChecksumMismatch{
+			Path:     remotePath,
+			Expected: expected,
+			Actual:   actual,
+		}
+	}
+
+	return nil
+}
+
+// randomHex returns a random hex string that is
+// used to generate unique names for remote files.
+func randomHex(bytes int) string {
+	buffer := make([]byte, bytes)
+	// The error is ignored as it is always nil.
+	rand.Read(buffer)
+
+	return hex.EncodeToString(buffer)
+}
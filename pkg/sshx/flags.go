@@ -0,0 +1,56 @@
+package sshx
+
+import (
+	"flag"
+	"strings"
+)
+
+// stringList is a flag value for comma-separated lists.
+type stringList struct {
+	list *[]string
+}
+
+// String returns the list as a comma-separated string.
+func (l stringList) String() string {
+	if l.list == nil {
+		return ""
+	}
+
+	return strings.Join(*l.list, ",")
+}
+
+// Set parses a comma-separated list.
+func (l stringList) Set(value string) error {
+	*l.list = strings.Split(value, ",")
+	return nil
+}
+
+// RegisterFlags registers a flag for every field of the config, such as
+// "--<prefix>-host", which populates the config once the flag set is
+// parsed. The current values of the config are used as defaults. Lists
+// are passed as comma-separated values. The flags are not prefixed if
+// the prefix is empty.
+func (config *Config) RegisterFlags(fs *flag.FlagSet, prefix string) {
+	name := func(flag string) string {
+		if prefix == "" {
+			return flag
+		}
+
+		return prefix + "-" + flag
+	}
+
+	fs.StringVar(&config.Host, name("host"), config.Host, "host of the SSH server")
+	fs.IntVar(&config.Port, name("port"), config.Port, "port of the SSH server")
+	fs.StringVar(&config.User, name("user"), config.User, "user to log in as")
+	fs.StringVar(&config.Password, name("password"), config.Password, "password of the user")
+	fs.StringVar(&config.KeyFile, name("key-file"), config.KeyFile, "path of the private key")
+	fs.StringVar(&config.Key, name("key"), config.Key, "PEM-encoded private key")
+	fs.StringVar(&config.Passphrase, name("passphrase"), config.Passphrase, "passphrase of the private key")
+	fs.StringVar(&config.Fingerprint, name("fingerprint"), config.Fingerprint, "expected fingerprint of the host key")
+	fs.Var(stringList{&config.HostKeyAlgorithms}, name("host-key-algorithms"), "comma-separated host key algorithms")
+	fs.Var(stringList{&config.KeyExchanges}, name("key-exchanges"), "comma-separated key exchange algorithms")
+	fs.Var(stringList{&config.Ciphers}, name("ciphers"), "comma-separated ciphers")
+	fs.Var(stringList{&config.MACs}, name("macs"), "comma-separated MAC algorithms")
+	fs.DurationVar(&config.Timeout, name("timeout"), config.Timeout, "timeout for establishing a connection")
+	fs.StringVar(&config.KnownHostsFile, name("known-hosts-file"), config.KnownHostsFile, "path of the known hosts file")
+}
@@ -0,0 +1,146 @@
+package sshx
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+const (
+	// nodeJoinTimeout is the maximum time to wait for a node to join the cluster.
+	nodeJoinTimeout = time.Minute * 5
+)
+
+// JoinServerNode installs k3s in server mode and joins the node to the
+// existing HA cluster of the server URL, such as "https://10.0.0.1:6443".
+// It blocks until the node is registered and ready.
+func (client *Client) JoinServerNode(serverURL, token string) error {
+	config, err := client.readK3SConfigMap()
+	if err != nil {
+		return err
+	}
+
+	config["server"] = serverURL
+	config["token"] = token
+	config["cluster-init"] = false
+
+	client.Logger.Info().Str("server", serverURL).Msg("Configuring cluster join")
+	if err := client.writeK3SConfigMap(config); err != nil {
+		return err
+	}
+
+	// The server and token are read from the configuration file.
+	client.Logger.Info().Msg("Running installation script")
+	if _, err := client.output(Cmd{
+		Cmd: fmt.Sprintf("set -o pipefail && curl -sfL %s | sudo INSTALL_K3S_EXEC=server sh -", K3SInstallScriptURL),
+	}); err != nil {
+		return err
+	}
+
+	name, err := client.GetK3SAgentNodeName()
+	if err != nil {
+		return err
+	}
+
+	if err := client.PollUntil(func(client *Client) (bool, error) {
+		nodes, err := client.GetK3SServerNodes()
+		if err == nil {
+			for _, node := range nodes {
+				if node.Name == name && node.Ready {
+					return true, nil
+				}
+			}
+			err = fmt.Errorf("node %s not ready", name)
+		}
+
+		client.Logger.Info().Str("node", name).Msg("Waiting for node to join")
+		return false, err
+	}, k3sPollInterval, nodeJoinTimeout); err != nil {
+		return fmt.Errorf("node not joined: %w", err)
+	}
+
+	return nil
+}
+
+// etcdMemberList is the output of "etcdctl member list -w json".
+type etcdMemberList struct {
+	Members []struct {
+		ID   uint64 `json:"ID"`
+		Name string `json:"name"`
+	} `json:"members"`
+}
+
+// minEtcdMembers is the minimum number of etcd members that
+// must remain after removing a server to tolerate a failure.
+const minEtcdMembers = 3
+
+// RemoveServerNode removes this server from the HA cluster. The node is
+// drained and deleted from Kubernetes, its etcd member is removed and k3s
+// is stopped before the data directory of k3s is deleted. It returns
+// ErrClusterTooSmall if fewer than 3 etcd members would remain. As the
+// data of the connected node is deleted, the node name must match it.
+func (client *Client) RemoveServerNode(nodeName string) error {
+	if err := client.ensureEtcdNode(); err != nil {
+		return err
+	}
+
+	localName, err := client.GetK3SAgentNodeName()
+	if err != nil {
+		return err
+	}
+	if nodeName != localName {
+		return fmt.Errorf("node %s is not the connected node %s", nodeName, localName)
+	}
+
+	output, err := client.etcdctl("member list -w json")
+	if err != nil {
+		return err
+	}
+
+	members := new(etcdMemberList)
+	if err := json.Unmarshal([]byte(output), members); err != nil {
+		return err
+	}
+
+	if remaining := len(members.Members) - 1; remaining < minEtcdMembers {
+		return fmt.Errorf("%w: %d etcd members would remain", ErrClusterTooSmall, remaining)
+	}
+
+	// K3s names the etcd members after the node with a random suffix.
+	memberName := regexp.MustCompile("^" + regexp.QuoteMeta(nodeName) + "-[0-9a-f]{8}$")
+	var memberID uint64
+	for _, member := range members.Members {
+		if memberName.MatchString(member.Name) {
+			memberID = member.ID
+		}
+	}
+	if memberID == 0 {
+		return fmt.Errorf("no etcd member found for node: %s", nodeName)
+	}
+
+	client.Logger.Info().Str("node", nodeName).Msg("Draining node")
+	if _, err := client.kubectl(fmt.Sprintf("drain %s --ignore-daemonsets --delete-emptydir-data --timeout=%ds", nodeName, int(nodeJoinTimeout.Seconds()))); err != nil {
+		return err
+	}
+
+	client.Logger.Info().Str("node", nodeName).Msg("Deleting node")
+	if _, err := client.kubectl("delete node " + nodeName); err != nil {
+		return err
+	}
+
+	// K3s may already have removed the member after the node was deleted.
+	client.Logger.Info().Str("node", nodeName).Msg("Removing etcd member")
+	if _, err := client.etcdctl(fmt.Sprintf("member remove %x", memberID)); err != nil {
+		client.Logger.Warn().Err(err).Msg("Failed to remove etcd member")
+	}
+
+	if err := client.ServiceStop(client.k3sService()); err != nil {
+		return err
+	}
+
+	client.Logger.Info().Str("path", K3SDataDir).Msg("Removing k3s data")
+	return client.Do(Cmd{
+		Cmd: "sudo rm -rf " + K3SDataDir,
+	})
+}
@@ -0,0 +1,140 @@
+package sshx
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+	"time"
+)
+
+const (
+	// HAProxyConfigPath is the location of the HAProxy configuration.
+	HAProxyConfigPath = "/etc/haproxy/haproxy.cfg"
+)
+
+// HAProxyConfig describes a TCP load balancer, such as a load balancer
+// for the k3s API servers of a cluster with multiple server nodes.
+type HAProxyConfig struct {
+	// Frontends are the addresses the load balancer listens on.
+	Frontends []HAProxyFrontend
+	// Backends are the groups of servers traffic is balanced across.
+	Backends []HAProxyBackend
+	// HealthCheck configures the health checks of all servers.
+	HealthCheck HAProxyHealthCheck
+}
+
+// HAProxyFrontend forwards traffic from a bind address to a backend.
+type HAProxyFrontend struct {
+	// Name is the unique name of the frontend.
+	Name string
+	// Bind is the address to listen on, such as "*:6443".
+	Bind string
+	// Backend is the name of the backend to forward traffic to.
+	Backend string
+}
+
+// HAProxyBackend is a group of servers.
+type HAProxyBackend struct {
+	// Name is the unique name of the backend.
+	Name string
+	// Servers maps server names to addresses, such as "10.0.0.1:6443".
+	Servers map[string]string
+}
+
+// HAProxyHealthCheck configures the TCP health checks of the servers.
+type HAProxyHealthCheck struct {
+	// Interval is the interval between two checks. Defaults to 2s.
+	Interval time.Duration
+	// Fall is the number of failed checks after which a server is
+	// considered unavailable. Defaults to 3.
+	Fall int
+	// Rise is the number of successful checks after which a server
+	// is considered available again. Defaults to 2.
+	Rise int
+}
+
+// haproxyTemplate renders the HAProxy configuration. All
+// traffic is balanced on the TCP layer to forward TLS as is.
+var haproxyTemplate = template.Must(template.New("haproxy").Parse(`# This file is managed by k3se.
+global
+    log /dev/log local0
+    daemon
+
+defaults
+    log global
+    mode tcp
+    option tcplog
+    timeout connect 5s
+    timeout client 1m
+    timeout server 1m
+{{ range .Frontends }}
+frontend {{ .Name }}
+    bind {{ .Bind }}
+    default_backend {{ .Backend }}
+{{ end }}{{ range .Backends }}
+backend {{ .Name }}
+    balance leastconn
+    option tcp-check
+    default-server inter {{ $.Interval }} fall {{ $.HealthCheck.Fall }} rise {{ $.HealthCheck.Rise }}
+{{- range $name, $address := .Servers }}
+    server {{ $name }} {{ $address }} check
+{{- end }}
+{{ end }}`))
+
+// ConfigureHAProxy writes the HAProxy configuration and reloads HAProxy.
+// The configuration is validated before it replaces the previous one,
+// which is kept if the new configuration is invalid.
+func (client *Client) ConfigureHAProxy(config HAProxyConfig) error {
+	backends := make(map[string]bool)
+	for _, backend := range config.Backends {
+		if len(backend.Servers) == 0 {
+			return fmt.Errorf("haproxy backend has no servers: %s", backend.Name)
+		}
+		backends[backend.Name] = true
+	}
+	for _, frontend := range config.Frontends {
+		if !backends[frontend.Backend] {
+			return fmt.Errorf("haproxy frontend references unknown backend: %s", frontend.Backend)
+		}
+	}
+
+	if config.HealthCheck.Interval == 0 {
+		config.HealthCheck.Interval = 2 * time.Second
+	}
+	if config.HealthCheck.Fall == 0 {
+		config.HealthCheck.Fall = 3
+	}
+	if config.HealthCheck.Rise == 0 {
+		config.HealthCheck.Rise = 2
+	}
+
+	content := new(bytes.Buffer)
+	if err := haproxyTemplate.Execute(content, struct {
+		HAProxyConfig
+		Interval string
+	}{
+		HAProxyConfig: config,
+		// HAProxy expects the interval in milliseconds.
+		Interval: fmt.Sprintf("%dms", config.HealthCheck.Interval.Milliseconds()),
+	}); err != nil {
+		return err
+	}
+
+	client.Logger.Info().Int("frontends", len(config.Frontends)).Int("backends", len(config.Backends)).Msg("Configuring HAProxy")
+	tmpPath := fmt.Sprintf("%s.k3se-%s", HAProxyConfigPath, randomHex(4))
+	if err := client.AtomicWriteFile(tmpPath, content.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	if _, err := client.output(Cmd{
+		Cmd: "sudo haproxy -c -f " + tmpPath,
+	}); err != nil {
+		client.Do(Cmd{Cmd: "sudo rm -f " + tmpPath})
+		return fmt.Errorf("invalid haproxy config: %w", err)
+	}
+
+	defer client.invalidateFile(HAProxyConfigPath)
+	return client.Do(Cmd{
+		Cmd: fmt.Sprintf("sudo mv -f %s %s && sudo systemctl reload-or-restart haproxy", tmpPath, HAProxyConfigPath),
+	})
+}
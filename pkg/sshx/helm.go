@@ -0,0 +1,70 @@
+package sshx
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	// HelmReleaseURL is the base URL of the Helm release artifacts.
+	HelmReleaseURL = "https://get.helm.sh"
+	// HelmBinaryPath is the location of the Helm binary.
+	HelmBinaryPath = "/usr/local/bin/helm"
+)
+
+// InstallHelm installs the Helm CLI of the specified version, such as
+// "v3.16.3", on the node. The release is downloaded on the node for the
+// architecture of the node and the installation is verified afterwards.
+func (client *Client) InstallHelm(version string) error {
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+
+	arch, err := client.GetArch()
+	if err != nil {
+		return err
+	}
+
+	tmpDir := "/tmp/k3se-helm-" + randomHex(8)
+	tarball := tmpDir + "/helm.tar.gz"
+	defer client.Do(Cmd{
+		Cmd: "rm -rf " + tmpDir,
+	})
+
+	if err := client.Do(Cmd{
+		Cmd: "mkdir -p " + tmpDir,
+	}); err != nil {
+		return err
+	}
+
+	releaseURL := fmt.Sprintf("%s/helm-%s-linux-%s.tar.gz", HelmReleaseURL, version, arch)
+	checksum, err := client.FetchSHA256(releaseURL+".sha256sum", path.Base(releaseURL))
+	if err != nil {
+		return err
+	}
+
+	if err := client.DownloadURL(releaseURL, tarball, checksum); err != nil {
+		return err
+	}
+
+	client.Logger.Info().Str("version", version).Msg("Installing Helm")
+	if err := client.Do(Cmd{
+		Cmd: fmt.Sprintf("tar xzf %[1]s -C %[2]s && sudo install -m 755 %[2]s/linux-%[3]s/helm %[4]s", tarball, tmpDir, arch, HelmBinaryPath),
+	}); err != nil {
+		return err
+	}
+
+	installed, err := client.output(Cmd{
+		Cmd: HelmBinaryPath + " version --short",
+	})
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(installed, version) {
+		return fmt.Errorf("unexpected helm version: %s", installed)
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package sshx
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// WriteHtpasswd writes an Apache htpasswd file with bcrypt-hashed passwords
+// for the specified credentials, which map usernames to passwords. This is
+// used to secure the registry mirror of k3s.
+func (client *Client) WriteHtpasswd(remotePath string, credentials map[string]string) error {
+	// Sort the usernames to produce a stable file.
+	usernames := make([]string, 0, len(credentials))
+	for username := range credentials {
+		if username == "" || strings.Contains(username, ":") {
+			return fmt.Errorf("invalid htpasswd username: %q", username)
+		}
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+
+	htpasswd := new(bytes.Buffer)
+	for _, username := range usernames {
+		hash, err := bcrypt.GenerateFromPassword([]byte(credentials[username]), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(htpasswd, "%s:%s\n", username, hash)
+	}
+
+	return client.AtomicWriteFile(remotePath, htpasswd.Bytes(), 0640)
+}
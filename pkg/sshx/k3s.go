@@ -0,0 +1,418 @@
+package sshx
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// K3SReleaseURL is the base URL of the k3s release artifacts.
+	K3SReleaseURL = "https://github.com/k3s-io/k3s/releases/download"
+	// K3SLogPath is the location of the k3s log file on hosts without journald.
+	K3SLogPath = "/var/log/k3s.log"
+	// K3SBinaryPath is the location of the k3s binary.
+	K3SBinaryPath = "/usr/local/bin/k3s"
+	// K3SDataDir is the data directory of k3s.
+	K3SDataDir = "/var/lib/rancher/k3s"
+	// K3STokenPath is the location of the cluster token on k3s servers.
+	K3STokenPath = "/var/lib/rancher/k3s/server/token"
+	// K3SInstallScriptURL is the URL of the k3s installation script.
+	K3SInstallScriptURL = "https://get.k3s.io"
+	// k3sReadyTimeout is the maximum time to wait for k3s after a restart.
+	k3sReadyTimeout = time.Minute * 2
+	// k3sPollInterval is the interval at which the k3s readiness is polled.
+	k3sPollInterval = time.Second * 5
+	// k3sAttemptTimeout is the maximum duration of a single readiness check.
+	k3sAttemptTimeout = time.Second * 10
+)
+
+// k3sBinaries maps the supported GOARCH names
+// to the names of the k3s release artifacts.
+var k3sBinaries = map[string]string{
+	"amd64": "k3s",
+	"arm64": "k3s-arm64",
+	"arm":   "k3s-armhf",
+	"s390x": "k3s-s390x",
+}
+
+// GetK3SBinaryURL returns the download URL of the k3s binary for the
+// specified version, such as "v1.31.2+k3s1", and GOARCH architecture.
+func GetK3SBinaryURL(version, arch string) (string, error) {
+	if version == "" {
+		return "", errors.New("no k3s version specified")
+	}
+
+	binary, ok := k3sBinaries[arch]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedArch, arch)
+	}
+
+	return fmt.Sprintf("%s/%s/%s", K3SReleaseURL, url.PathEscape(version), binary), nil
+}
+
+// InstallK3SBinary downloads the k3s binary of the specified version for
+// the architecture of the node and replaces the installed binary. The
+// download happens on the node itself. Please note that k3s must be
+// restarted for the new binary to take effect.
+func (client *Client) InstallK3SBinary(version string) error {
+	tmpPath, err := client.DownloadK3SBinary(version)
+	if err != nil {
+		return err
+	}
+
+	client.Logger.Info().Str("version", version).Msg("Installing k3s binary")
+	return client.ReplaceK3SBinary(tmpPath)
+}
+
+// DownloadK3SBinary downloads the k3s binary of the specified version for
+// the architecture of the node to a temporary file on the node and verifies
+// its checksum. It returns the path of the file, which may be passed to
+// ReplaceK3SBinary. This allows to download the binary before k3s is stopped.
+func (client *Client) DownloadK3SBinary(version string) (string, error) {
+	arch, err := client.GetArch()
+	if err != nil {
+		return "", err
+	}
+
+	binaryURL, err := GetK3SBinaryURL(version, arch)
+	if err != nil {
+		return "", err
+	}
+
+	// The release contains a checksum file for every architecture.
+	checksumURL := fmt.Sprintf("%s/%s/sha256sum-%s.txt", K3SReleaseURL, url.PathEscape(version), arch)
+	checksum, err := client.FetchSHA256(checksumURL, path.Base(binaryURL))
+	if err != nil {
+		return "", err
+	}
+
+	tmpPath := "/tmp/k3se-k3s-" + randomHex(8)
+	if err := client.DownloadURL(binaryURL, tmpPath, checksum); err != nil {
+		return "", err
+	}
+
+	return tmpPath, nil
+}
+
+// ReplaceK3SBinary replaces the installed k3s binary with a binary that
+// was downloaded via DownloadK3SBinary and removes the downloaded file.
+func (client *Client) ReplaceK3SBinary(tmpPath string) error {
+	return client.Do(Cmd{
+		Cmd: fmt.Sprintf("sudo install -m 755 %s %s && rm -f %s", tmpPath, K3SBinaryPath, tmpPath),
+	})
+}
+
+// GetK3SVersion returns the version of the installed k3s binary,
+// such as "v1.31.2+k3s1", or ErrK3SNotInstalled if k3s is missing.
+func (client *Client) GetK3SVersion() (string, error) {
+	if err := client.ensureK3SInstalled(); err != nil {
+		return "", err
+	}
+
+	output, err := client.output(Cmd{
+		Cmd: "k3s --version",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// The first line has the format "k3s version <version> (<commit>)".
+	fields := strings.Fields(strings.SplitN(output, "\n", 2)[0])
+	if len(fields) < 3 {
+		return "", fmt.Errorf("malformed k3s version: %s", output)
+	}
+
+	return fields[2], nil
+}
+
+// ValidateK3SConfig checks the syntax of a local k3s configuration file
+// by uploading it to the remote host and letting k3s parse it without
+// starting the server. The uploaded file is removed afterwards.
+func (client *Client) ValidateK3SConfig(configPath string) error {
+	tmpPath := fmt.Sprintf("/tmp/k3se-config-%s.yaml", randomHex(8))
+	if err := client.UploadFile(configPath, tmpPath); err != nil {
+		return err
+	}
+	defer client.SFTP.Remove(tmpPath)
+
+	stderr := new(strings.Builder)
+	err := client.Do(Cmd{
+		Cmd:    fmt.Sprintf("sudo k3s server --config %s --help", tmpPath),
+		Stderr: stderr,
+	})
+
+	output := strings.TrimSpace(stderr.String())
+	if err != nil || strings.Contains(strings.ToLower(output), "error") {
+		if output == "" {
+			return fmt.Errorf("invalid k3s config: %w", err)
+		}
+		return fmt.Errorf("invalid k3s config: %s", output)
+	}
+
+	return nil
+}
+
+// RotateK3SToken generates a new random cluster token and rotates the
+// token of the k3s server via "k3s token rotate", which re-encrypts the
+// bootstrap data with the new token. If the token is set in the k3s
+// configuration file, it is updated as well before the server is
+// restarted. Please note that the caller is responsible for propagating
+// the new token to the other servers and the agents.
+func (client *Client) RotateK3SToken() (string, error) {
+	if err := client.ensureK3SServer(); err != nil {
+		return "", err
+	}
+
+	oldToken, err := client.ReadFile(K3STokenPath)
+	if err != nil {
+		return "", err
+	}
+
+	token := randomHex(32)
+
+	client.Logger.Info().Msg("Rotating cluster token")
+	defer client.invalidateFile(K3STokenPath)
+	if _, err := client.output(Cmd{
+		Cmd: fmt.Sprintf("sudo k3s token rotate --token %s --new-token %s",
+			shellQuote(string(bytes.TrimSpace(oldToken))), shellQuote(token)),
+	}); err != nil {
+		return "", err
+	}
+
+	config, err := client.readK3SConfigMap()
+	if err != nil {
+		return "", err
+	}
+
+	if _, ok := config["token"]; ok {
+		config["token"] = token
+		if err := client.writeK3SConfigMap(config); err != nil {
+			return "", err
+		}
+	}
+
+	if err := client.ServiceRestart(client.k3sService()); err != nil {
+		return "", err
+	}
+
+	if err := client.WaitForK3SReady(k3sReadyTimeout); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// GetK3STokenSHA256 returns the hex-encoded SHA-256 hash of the cluster
+// token. This allows to verify that all nodes share the same token
+// without exposing the token in logs.
+func (client *Client) GetK3STokenSHA256() (string, error) {
+	if err := client.ensureK3SServer(); err != nil {
+		return "", err
+	}
+
+	token, err := client.ReadFile(K3STokenPath)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(bytes.TrimSpace(token))
+
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// RenewK3SCerts rotates the certificates of a k3s server, which expire after
+// one year by default. The server is stopped during the rotation and the
+// function blocks until the server is ready again. If the rotation fails,
+// the server is started with its previous certificates.
+func (client *Client) RenewK3SCerts() error {
+	if err := client.ensureK3SServer(); err != nil {
+		return err
+	}
+
+	if err := client.StopK3S(); err != nil {
+		return err
+	}
+
+	client.Logger.Info().Msg("Rotating certificates")
+	if _, err := client.output(Cmd{
+		Cmd: "sudo k3s certificate rotate",
+	}); err != nil {
+		if startErr := client.StartK3S(); startErr != nil {
+			return fmt.Errorf("certificate rotation failed: %w, k3s left stopped: %w", err, startErr)
+		}
+		return fmt.Errorf("certificate rotation failed: %w", err)
+	}
+
+	if err := client.StartK3S(); err != nil {
+		return err
+	}
+
+	return client.WaitForK3SReady(k3sReadyTimeout)
+}
+
+// StartK3S starts the k3s service and verifies that it is active.
+func (client *Client) StartK3S() error {
+	service := client.k3sService()
+
+	if err := client.ServiceStart(service); err != nil {
+		return err
+	}
+
+	return client.expectServiceStatus(service, "active")
+}
+
+// StopK3S stops the k3s service and verifies that it is inactive.
+func (client *Client) StopK3S() error {
+	service := client.k3sService()
+
+	if err := client.ServiceStop(service); err != nil {
+		return err
+	}
+
+	return client.expectServiceStatus(service, "inactive")
+}
+
+// expectServiceStatus returns an error if the service is not in the expected state.
+func (client *Client) expectServiceStatus(service string, expected string) error {
+	status, err := client.ServiceStatus(service)
+	if err != nil {
+		return err
+	}
+
+	if status != expected {
+		return fmt.Errorf("service %s is %s, expected %s", service, status, expected)
+	}
+
+	return nil
+}
+
+// GetK3SLogs returns the last lines of the k3s logs. The logs are read from
+// journald or from the k3s log file if journald is not available.
+func (client *Client) GetK3SLogs(lines int) (string, error) {
+	return client.output(Cmd{
+		Cmd: client.k3sLogsCommand(fmt.Sprintf("-n %d", lines)),
+	})
+}
+
+// StreamK3SLogs follows the k3s logs and sends every new line to the
+// returned channel. The channel is closed once the context is done
+// or the remote command terminates.
+func (client *Client) StreamK3SLogs(ctx context.Context) (<-chan string, error) {
+	command := client.k3sLogsCommand("-f -n 0")
+
+	reader, writer := io.Pipe()
+	go func() {
+		err := client.DoContext(ctx, Cmd{
+			Cmd:    command,
+			Stdout: writer,
+		})
+		writer.CloseWithError(err)
+	}()
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		defer reader.Close()
+
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return lines, nil
+}
+
+// k3sLogsCommand returns the command to read the k3s logs with the
+// specified arguments, which must be compatible with journalctl and tail.
+func (client *Client) k3sLogsCommand(args string) string {
+	if _, err := client.output(Cmd{
+		Cmd: "command -v journalctl",
+	}); err != nil {
+		return fmt.Sprintf("sudo tail %s %s", args, K3SLogPath)
+	}
+
+	return fmt.Sprintf("sudo journalctl -u %s --no-pager %s", client.k3sService(), args)
+}
+
+// ensureK3SInstalled returns ErrK3SNotInstalled if
+// the k3s binary is not present on the remote host.
+func (client *Client) ensureK3SInstalled() error {
+	if _, err := client.output(Cmd{
+		Cmd: "command -v k3s",
+	}); err != nil {
+		return ErrK3SNotInstalled
+	}
+
+	return nil
+}
+
+// ensureK3SServer returns ErrK3SNotInstalled if k3s is not
+// installed and ErrK3SNotServer if the node is an agent.
+func (client *Client) ensureK3SServer() error {
+	if err := client.ensureK3SInstalled(); err != nil {
+		return err
+	}
+
+	if client.k3sService() != "k3s" {
+		return ErrK3SNotServer
+	}
+
+	return nil
+}
+
+// k3sService returns the name of the systemd service of k3s,
+// which depends on whether the node is a server or an agent.
+func (client *Client) k3sService() string {
+	if err := client.Do(Cmd{
+		Cmd: "test -f /etc/systemd/system/k3s-agent.service",
+	}); err == nil {
+		return "k3s-agent"
+	}
+
+	return "k3s"
+}
+
+// WaitForK3SReady blocks until the API server of k3s responds, which is
+// more reliable than waiting for the systemd service. Each attempt is
+// aborted after a short timeout. It returns ErrK3SReadyTimeout with the
+// last error once the overall timeout expires.
+func (client *Client) WaitForK3SReady(timeout time.Duration) error {
+	if err := client.PollUntil(func(client *Client) (bool, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), k3sAttemptTimeout)
+		defer cancel()
+
+		stderr := new(bytes.Buffer)
+		if err := client.DoContext(ctx, Cmd{
+			Cmd:    "sudo k3s kubectl get nodes",
+			Stdout: io.Discard,
+			Stderr: stderr,
+		}); err != nil {
+			client.Logger.Info().Msg("Waiting for k3s to become ready")
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return false, fmt.Errorf("%w: %s", err, msg)
+			}
+			return false, err
+		}
+
+		return true, nil
+	}, k3sPollInterval, timeout); err != nil {
+		return fmt.Errorf("%w: %w", ErrK3SReadyTimeout, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,335 @@
+package sshx
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// K3SConfigPath is the location of the k3s configuration file.
+	K3SConfigPath = "/etc/rancher/k3s/config.yaml"
+	// DefaultClusterCIDR is the default pod network of k3s.
+	DefaultClusterCIDR = "10.42.0.0/16"
+	// DefaultServiceCIDR is the default service network of k3s.
+	DefaultServiceCIDR = "10.43.0.0/16"
+)
+
+// k3sConfigSecrets are the keys of the k3s configuration that contain
+// secrets, which must not be exposed in logs, diffs or snapshots.
+var k3sConfigSecrets = map[string]bool{
+	"token":              true,
+	"agent-token":        true,
+	"etcd-s3-access-key": true,
+	"etcd-s3-secret-key": true,
+}
+
+// K3SConfig describes the parts of the k3s configuration
+// file that are relevant for auditing a node.
+type K3SConfig struct {
+	ClusterInit bool       `yaml:"cluster-init,omitempty"`
+	ClusterCIDR StringList `yaml:"cluster-cidr,omitempty"`
+	ServiceCIDR StringList `yaml:"service-cidr,omitempty"`
+	TLSSan      StringList `yaml:"tls-san,omitempty"`
+	NodeName    string     `yaml:"node-name,omitempty"`
+	DataDir     string     `yaml:"data-dir,omitempty"`
+}
+
+// StringList is a list of strings that may also be specified as a single
+// string in YAML, which mirrors how k3s parses its configuration file.
+type StringList []string
+
+// UnmarshalYAML decodes a single string or a list of strings.
+func (l *StringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var item string
+		if err := value.Decode(&item); err != nil {
+			return err
+		}
+
+		*l = StringList{item}
+		return nil
+	}
+
+	var items []string
+	if err := value.Decode(&items); err != nil {
+		return err
+	}
+
+	*l = items
+	return nil
+}
+
+// GetK3SConfig reads and parses the k3s configuration file of the node.
+func (client *Client) GetK3SConfig() (*K3SConfig, error) {
+	content, err := client.ReadFile(K3SConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	config := new(K3SConfig)
+	if err := yaml.Unmarshal(content, config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// PatchK3SConfig deep merges the patch into the k3s configuration file of
+// the node. Nested maps are merged, while all other values are replaced.
+// The changed keys are logged and k3s is restarted if AutoRestart is set.
+// It returns a unified diff of the changes with secrets redacted, which
+// is empty if the configuration is up-to-date.
+func (client *Client) PatchK3SConfig(patch map[string]interface{}) (string, error) {
+	config, err := client.readK3SConfigMap()
+	if err != nil {
+		return "", err
+	}
+
+	// The previous config is serialized before merging,
+	// as the nested maps are modified in place.
+	original := maps.Clone(config)
+	fromYAML, err := yaml.Marshal(redactK3SConfig(original, nil))
+	if err != nil {
+		return "", err
+	}
+
+	before := flattenConfig(config, "")
+	deepMerge(config, patch)
+	after := flattenConfig(config, "")
+
+	changed := diffKeys(before, after)
+	if len(changed) == 0 {
+		client.Logger.Info().Msg("K3s config is up-to-date")
+		return "", nil
+	}
+
+	for _, key := range changed {
+		client.Logger.Info().Str("key", key).Msg("Patching k3s config")
+	}
+
+	toYAML, err := yaml.Marshal(redactK3SConfig(config, original))
+	if err != nil {
+		return "", err
+	}
+	diff := unifiedDiff("a/config.yaml", "b/config.yaml", string(fromYAML), string(toYAML))
+
+	if err := client.writeK3SConfigMap(config); err != nil {
+		return "", err
+	}
+
+	if client.AutoRestart {
+		return diff, client.ServiceRestart(client.k3sService())
+	}
+
+	return diff, nil
+}
+
+// redactK3SConfig returns a shallow copy of the k3s configuration with all
+// secrets redacted. Secrets that differ from the previous configuration,
+// if any, are marked as changed.
+func redactK3SConfig(config, previous map[string]interface{}) map[string]interface{} {
+	redactedConfig := make(map[string]interface{}, len(config))
+
+	for key, value := range config {
+		if k3sConfigSecrets[key] {
+			if previous != nil && !reflect.DeepEqual(previous[key], value) {
+				value = redactedChanged
+			} else {
+				value = redacted
+			}
+		}
+		redactedConfig[key] = value
+	}
+
+	return redactedConfig
+}
+
+// k3sServerFlags are the flags that may be changed via SetK3SServerFlag.
+// Flags that are only honored during the initialization of a cluster,
+// such as "cluster-cidr", or that affect its security are excluded. This
+// includes the "*-arg" flags, which pass arbitrary flags to components.
+var k3sServerFlags = map[string]bool{
+	"node-name":                   true,
+	"node-label":                  true,
+	"node-taint":                  true,
+	"node-ip":                     true,
+	"node-external-ip":            true,
+	"tls-san":                     true,
+	"disable":                     true,
+	"etcd-snapshot-schedule-cron": true,
+	"etcd-snapshot-retention":     true,
+	"default-local-storage-path":  true,
+	"embedded-registry":           true,
+	"disable-helm-controller":     true,
+	"disable-cloud-controller":    true,
+}
+
+// SetK3SServerFlag sets a flag in the k3s configuration file, such as
+// "node-label". The value is parsed as YAML, which allows to set
+// booleans and lists. Only flags of an allowlist may be changed. If
+// the flag changed, k3s is restarted if AutoRestart is set. Otherwise
+// ErrRestartRequired is returned.
+func (client *Client) SetK3SServerFlag(flag, value string) error {
+	if !k3sServerFlags[flag] {
+		return fmt.Errorf("unsupported k3s server flag: %s", flag)
+	}
+
+	if err := client.ensureK3SServer(); err != nil {
+		return err
+	}
+
+	var parsed interface{}
+	if err := yaml.Unmarshal([]byte(value), &parsed); err != nil {
+		return err
+	}
+
+	config, err := client.readK3SConfigMap()
+	if err != nil {
+		return err
+	}
+
+	if current, ok := config[flag]; ok && reflect.DeepEqual(current, parsed) {
+		client.Logger.Info().Str("flag", flag).Msg("K3s server flag is up-to-date")
+		return nil
+	}
+
+	client.Logger.Info().Str("flag", flag).Msg("Setting k3s server flag")
+	config[flag] = parsed
+	if err := client.writeK3SConfigMap(config); err != nil {
+		return err
+	}
+
+	if !client.AutoRestart {
+		return ErrRestartRequired
+	}
+
+	return client.ServiceRestart(client.k3sService())
+}
+
+// SetupK3SClusterInit prepares the node to initialize a new HA cluster with
+// embedded etcd by enabling "cluster-init" in the k3s configuration. The
+// pod and service networks are set explicitly as they cannot be changed
+// once the cluster has been initialized. Existing networks are preserved.
+// This must be called before the installation script is run.
+func (client *Client) SetupK3SClusterInit() error {
+	config, err := client.readK3SConfigMap()
+	if err != nil {
+		return err
+	}
+
+	config["cluster-init"] = true
+	if _, ok := config["cluster-cidr"]; !ok {
+		config["cluster-cidr"] = DefaultClusterCIDR
+	}
+	if _, ok := config["service-cidr"]; !ok {
+		config["service-cidr"] = DefaultServiceCIDR
+	}
+
+	client.Logger.Info().Msg("Configuring cluster initialization")
+	return client.writeK3SConfigMap(config)
+}
+
+// GetK3SAgentNodeName returns the name of the node in Kubernetes, which
+// is the "node-name" of the k3s configuration or the hostname by default.
+func (client *Client) GetK3SAgentNodeName() (string, error) {
+	config, err := client.GetK3SConfig()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	if config != nil && config.NodeName != "" {
+		return config.NodeName, nil
+	}
+
+	return client.GetHostname()
+}
+
+// readK3SConfigMap reads the k3s configuration file into a generic
+// map. A missing configuration file is treated as an empty config.
+func (client *Client) readK3SConfigMap() (map[string]interface{}, error) {
+	config := make(map[string]interface{})
+
+	content, err := client.ReadFile(K3SConfigPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return config, nil
+		}
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// writeK3SConfigMap writes the k3s configuration file.
+func (client *Client) writeK3SConfigMap(config map[string]interface{}) error {
+	content, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	return client.AtomicWriteFile(K3SConfigPath, content, 0600)
+}
+
+// deepMerge merges the source map into the destination map.
+func deepMerge(dst, src map[string]interface{}) {
+	for key, value := range src {
+		srcMap, srcIsMap := value.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+
+		if srcIsMap && dstIsMap {
+			deepMerge(dstMap, srcMap)
+			continue
+		}
+
+		dst[key] = value
+	}
+}
+
+// flattenConfig flattens nested maps into a single map
+// with keys separated by dots to simplify diffing.
+func flattenConfig(config map[string]interface{}, prefix string) map[string]interface{} {
+	flat := make(map[string]interface{})
+
+	for key, value := range config {
+		if nested, ok := value.(map[string]interface{}); ok {
+			for nestedKey, nestedValue := range flattenConfig(nested, prefix+key+".") {
+				flat[nestedKey] = nestedValue
+			}
+			continue
+		}
+
+		flat[prefix+key] = value
+	}
+
+	return flat
+}
+
+// diffKeys returns the sorted keys that differ between two flat maps.
+func diffKeys(before, after map[string]interface{}) []string {
+	var changed []string
+
+	for key, value := range after {
+		if old, ok := before[key]; !ok || !reflect.DeepEqual(old, value) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+
+	sort.Strings(changed)
+
+	return changed
+}
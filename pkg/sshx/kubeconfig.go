@@ -0,0 +1,150 @@
+package sshx
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	// K3SKubeconfigPath is the location of the admin kubeconfig on k3s servers.
+	K3SKubeconfigPath = "/etc/rancher/k3s/k3s.yaml"
+	// LocalKubeconfigPath is the location of the local kubeconfig.
+	LocalKubeconfigPath = "~/.kube/config"
+)
+
+// FetchKubeconfig returns the admin kubeconfig of the k3s server. Please
+// note that the server URL in the kubeconfig points to the loopback address.
+func (client *Client) FetchKubeconfig() ([]byte, error) {
+	return client.ReadFile(K3SKubeconfigPath)
+}
+
+// GetK3SServerURL returns the URL of the API server that other nodes, such
+// as agents, use to register with this server. The kubeconfig of k3s points
+// to the loopback address, which is why the host is replaced by the
+// "advertise-address" of the k3s configuration or by the address of the
+// SSH connection. The port is the "advertise-port", if configured.
+func (client *Client) GetK3SServerURL() (string, error) {
+	kubeconfig, err := client.FetchKubeconfig()
+	if err != nil {
+		return "", err
+	}
+
+	config, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return "", err
+	}
+
+	var server string
+	if context, ok := config.Contexts[config.CurrentContext]; ok {
+		if cluster, ok := config.Clusters[context.Cluster]; ok {
+			server = cluster.Server
+		}
+	}
+	if server == "" {
+		return "", errors.New("no cluster found for current context")
+	}
+
+	serverURL, err := url.Parse(server)
+	if err != nil {
+		return "", err
+	}
+
+	k3sConfig, err := client.readK3SConfigMap()
+	if err != nil {
+		return "", err
+	}
+
+	host, _, err := net.SplitHostPort(client.SSH.RemoteAddr().String())
+	if err != nil {
+		return "", err
+	}
+	if address, ok := k3sConfig["advertise-address"]; ok {
+		host = fmt.Sprint(address)
+	}
+
+	port := serverURL.Port()
+	if advertisePort, ok := k3sConfig["advertise-port"]; ok {
+		port = fmt.Sprint(advertisePort)
+	}
+
+	serverURL.Host = net.JoinHostPort(host, port)
+
+	return serverURL.String(), nil
+}
+
+// PatchKubeconfig replaces the server URL of all clusters in the kubeconfig,
+// such as the loopback address in the kubeconfig of k3s, with the address.
+func PatchKubeconfig(kubeconfig []byte, serverAddr string) ([]byte, error) {
+	config, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	SetKubeconfigServer(config, serverAddr)
+
+	return clientcmd.Write(*config)
+}
+
+// SetKubeconfigServer replaces the server URL of all clusters in a parsed
+// kubeconfig, which avoids parsing the kubeconfig again if it is modified
+// further.
+func SetKubeconfigServer(config *api.Config, serverAddr string) {
+	for _, cluster := range config.Clusters {
+		cluster.Server = serverAddr
+	}
+}
+
+// MergeKubeconfig merges the current context of the kubeconfig into the
+// local kubeconfig, which is created if it does not exist. The cluster,
+// user and context are renamed to the context name and the context is
+// selected as the current context.
+func (client *Client) MergeKubeconfig(kubeconfig []byte, contextName string) error {
+	newConfig, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	context, ok := newConfig.Contexts[newConfig.CurrentContext]
+	if !ok {
+		return errors.New("no current context found in kubeconfig")
+	}
+	cluster, ok := newConfig.Clusters[context.Cluster]
+	if !ok {
+		return errors.New("no cluster found for current context")
+	}
+	authInfo, ok := newConfig.AuthInfos[context.AuthInfo]
+	if !ok {
+		return errors.New("no user found for current context")
+	}
+
+	localPath, err := expandHome(LocalKubeconfigPath)
+	if err != nil {
+		return err
+	}
+
+	localConfig, err := clientcmd.LoadFromFile(localPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		localConfig = api.NewConfig()
+	}
+
+	merged := context.DeepCopy()
+	merged.Cluster = contextName
+	merged.AuthInfo = contextName
+
+	localConfig.Clusters[contextName] = cluster
+	localConfig.AuthInfos[contextName] = authInfo
+	localConfig.Contexts[contextName] = merged
+	localConfig.CurrentContext = contextName
+
+	client.Logger.Info().Str("context", contextName).Str("path", localPath).Msg("Merging kubeconfig")
+	return clientcmd.WriteToFile(*localConfig, localPath)
+}
@@ -0,0 +1,317 @@
+package sshx
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+var serviceCIDR = regexp.MustCompile(`service-cluster-ip-range=([^"\s,]+)`)
+
+// kubectl runs the kubectl command embedded in k3s with
+// the specified arguments and returns its output.
+func (client *Client) kubectl(args string) (string, error) {
+	return client.output(Cmd{
+		Cmd: "sudo k3s kubectl " + args,
+	})
+}
+
+// GetK3SPodCIDR returns the pod CIDR assigned to the first node of the cluster.
+func (client *Client) GetK3SPodCIDR() (string, error) {
+	cidr, err := client.kubectl("get nodes -o jsonpath='{.items[0].spec.podCIDR}'")
+	if err != nil {
+		return "", err
+	}
+
+	if cidr == "" {
+		return "", fmt.Errorf("no pod cidr assigned")
+	}
+
+	return cidr, nil
+}
+
+// GetK3SServiceCIDR returns the service CIDR of the cluster.
+func (client *Client) GetK3SServiceCIDR() (string, error) {
+	output, err := client.kubectl("cluster-info dump | grep -m 1 service-cluster-ip-range")
+	if err != nil {
+		return "", err
+	}
+
+	match := serviceCIDR.FindStringSubmatch(output)
+	if match == nil {
+		return "", fmt.Errorf("no service cidr found")
+	}
+
+	return match[1], nil
+}
+
+// ApplyManifest applies a multi-document Kubernetes manifest
+// via "kubectl apply" using server-side apply, which supports
+// large objects, such as custom resource definitions.
+func (client *Client) ApplyManifest(manifest []byte) error {
+	if err := client.ensureK3SServer(); err != nil {
+		return err
+	}
+
+	_, err := client.output(Cmd{
+		Cmd:   "sudo k3s kubectl apply --server-side --force-conflicts -f -",
+		Stdin: bytes.NewReader(manifest),
+	})
+
+	return err
+}
+
+// WaitForDeployment blocks until the rollout of a deployment has completed
+// or returns an error once the timeout expires. A deployment that does not
+// exist yet, for example because its chart is still being installed, is
+// waited for as well.
+func (client *Client) WaitForDeployment(namespace, name string, timeout time.Duration) error {
+	if err := client.PollUntil(func(client *Client) (bool, error) {
+		if _, err := client.kubectl(fmt.Sprintf("rollout status deployment/%s -n %s --timeout=%ds", name, namespace, int(k3sPollInterval.Seconds()))); err != nil {
+			client.Logger.Info().Str("deployment", name).Msg("Waiting for deployment to become ready")
+			return false, err
+		}
+
+		return true, nil
+	}, k3sPollInterval, timeout); err != nil {
+		return fmt.Errorf("deployment %s/%s not ready: %w", namespace, name, err)
+	}
+
+	return nil
+}
+
+// GetK3SNodeLabels returns the labels of a Kubernetes node.
+func (client *Client) GetK3SNodeLabels(node string) (map[string]string, error) {
+	return client.getNodeMetadata(node, "labels")
+}
+
+// GetK3SNodeAnnotations returns the annotations of a Kubernetes node.
+func (client *Client) GetK3SNodeAnnotations(node string) (map[string]string, error) {
+	return client.getNodeMetadata(node, "annotations")
+}
+
+// getNodeMetadata returns a map of the metadata of a Kubernetes node,
+// such as its "labels" or "annotations".
+func (client *Client) getNodeMetadata(node string, field string) (map[string]string, error) {
+	output, err := client.kubectl(fmt.Sprintf("get node %s -o jsonpath='{.metadata.%s}'", node, field))
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := make(map[string]string)
+	if output == "" {
+		return metadata, nil
+	}
+
+	if err := json.Unmarshal([]byte(output), &metadata); err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+// GetEventLogs returns the events of a namespace with the specified reason,
+// such as "FailedScheduling", sorted by their time of occurrence. Every line
+// has the format "<kind>/<name>: <message>". All events of the namespace are
+// returned if the reason is empty.
+func (client *Client) GetEventLogs(namespace, reason string) ([]string, error) {
+	args := fmt.Sprintf("get events -n %s --sort-by=.lastTimestamp", namespace)
+	if reason != "" {
+		args += " --field-selector reason=" + reason
+	}
+	args += ` -o jsonpath='{range .items[*]}{.involvedObject.kind}/{.involvedObject.name}: {.message}{"\n"}{end}'`
+
+	output, err := client.kubectl(args)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			events = append(events, line)
+		}
+	}
+
+	return events, nil
+}
+
+// NodeInfo describes a node of the cluster.
+type NodeInfo struct {
+	// Name is the name of the node in Kubernetes.
+	Name string
+	// InternalIP is the address of the node within the cluster network.
+	InternalIP string
+	// ExternalIP is the public address of the node, if any.
+	ExternalIP string
+	// Roles are the roles of the node, such as "control-plane" or "etcd".
+	Roles []string
+	// Ready reports whether the node is ready to run pods.
+	Ready bool
+	// K3SVersion is the version of k3s the kubelet of the node runs.
+	K3SVersion string
+}
+
+// nodeList is the subset of a Kubernetes node list used by NodeInfo.
+type nodeList struct {
+	Items []struct {
+		Metadata struct {
+			Name   string            `json:"name"`
+			Labels map[string]string `json:"labels"`
+		} `json:"metadata"`
+		Status struct {
+			Addresses []struct {
+				Type    string `json:"type"`
+				Address string `json:"address"`
+			} `json:"addresses"`
+			Conditions []struct {
+				Type   string `json:"type"`
+				Status string `json:"status"`
+			} `json:"conditions"`
+			NodeInfo struct {
+				KubeletVersion string `json:"kubeletVersion"`
+			} `json:"nodeInfo"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// nodeRoleLabelPrefix is the prefix of the labels that define the roles of a node.
+const nodeRoleLabelPrefix = "node-role.kubernetes.io/"
+
+// GetK3SServerNodes returns all nodes of the cluster as reported by the API
+// server of this k3s server, which allows to determine the cluster topology.
+func (client *Client) GetK3SServerNodes() ([]NodeInfo, error) {
+	if err := client.ensureK3SServer(); err != nil {
+		return nil, err
+	}
+
+	output, err := client.kubectl("get nodes -o json")
+	if err != nil {
+		return nil, err
+	}
+
+	list := new(nodeList)
+	if err := json.Unmarshal([]byte(output), list); err != nil {
+		return nil, err
+	}
+
+	nodes := make([]NodeInfo, 0, len(list.Items))
+	for _, item := range list.Items {
+		node := NodeInfo{
+			Name:       item.Metadata.Name,
+			K3SVersion: item.Status.NodeInfo.KubeletVersion,
+		}
+
+		for label := range item.Metadata.Labels {
+			if role, ok := strings.CutPrefix(label, nodeRoleLabelPrefix); ok {
+				node.Roles = append(node.Roles, role)
+			}
+		}
+		sort.Strings(node.Roles)
+
+		for _, address := range item.Status.Addresses {
+			switch address.Type {
+			case "InternalIP":
+				node.InternalIP = address.Address
+			case "ExternalIP":
+				node.ExternalIP = address.Address
+			}
+		}
+
+		for _, condition := range item.Status.Conditions {
+			if condition.Type == "Ready" {
+				node.Ready = condition.Status == "True"
+			}
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+// GetKubeletConfig returns the effective configuration of the kubelet
+// of this node as JSON, which is retrieved via the "configz" endpoint
+// of the node proxy of the API server.
+func (client *Client) GetKubeletConfig() ([]byte, error) {
+	if err := client.ensureK3SServer(); err != nil {
+		return nil, err
+	}
+
+	name, err := client.GetK3SAgentNodeName()
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := client.kubectl(fmt.Sprintf("get --raw /api/v1/nodes/%s/proxy/configz", name))
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(config), nil
+}
+
+// KubectlReleaseURL is the base URL of the Kubernetes release artifacts.
+const KubectlReleaseURL = "https://dl.k8s.io/release"
+
+// DownloadKubectl downloads kubectl of the specified version, such as
+// "v1.31.2", for the local operating system and architecture into the
+// local directory. The checksum of the binary is verified before it is
+// made executable.
+func DownloadKubectl(version, destDir string) error {
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+
+	binary := "kubectl"
+	if runtime.GOOS == "windows" {
+		binary += ".exe"
+	}
+	binaryURL := fmt.Sprintf("%s/%s/bin/%s/%s/%s", KubectlReleaseURL, version, runtime.GOOS, runtime.GOARCH, binary)
+
+	content, err := fetchURL(binaryURL)
+	if err != nil {
+		return err
+	}
+
+	checksum, err := fetchURL(binaryURL + ".sha256")
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.Sum256(content)
+	actual := hex.EncodeToString(hash[:])
+	if expected := strings.TrimSpace(string(checksum)); actual != expected {
+		return ErrChecksumMismatch{
+			Path:     binaryURL,
+			Expected: expected,
+			Actual:   actual,
+		}
+	}
+
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first to avoid replacing
+	// an existing binary with a partially written one.
+	destPath := filepath.Join(destDir, binary)
+	tmpPath := destPath + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0755); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, 0755); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, destPath)
+}
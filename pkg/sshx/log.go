@@ -0,0 +1,36 @@
+package sshx
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// logWriter is a writer that logs every line written to it.
+// This allows to follow the progress of remote commands.
+type logWriter struct {
+	logger *zerolog.Logger
+	buffer bytes.Buffer
+}
+
+// Write logs all complete lines and buffers the remainder.
+func (w *logWriter) Write(raw []byte) (int, error) {
+	w.buffer.Write(raw)
+
+	for {
+		line, err := w.buffer.ReadString('\n')
+		if err != nil {
+			// Put back the incomplete line.
+			w.buffer.Reset()
+			w.buffer.WriteString(line)
+			break
+		}
+
+		if line = strings.TrimSpace(line); line != "" {
+			w.logger.Debug().Msg(line)
+		}
+	}
+
+	return len(raw), nil
+}
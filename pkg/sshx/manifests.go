@@ -0,0 +1,247 @@
+package sshx
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// K3SManifestsDir is the directory on k3s servers containing manifests
+	// that are automatically deployed by the k3s deploy controller.
+	K3SManifestsDir = "/var/lib/rancher/k3s/server/manifests"
+	// MetricsServerRepoURL is the URL of the metrics-server chart repository.
+	MetricsServerRepoURL = "https://kubernetes-sigs.github.io/metrics-server/"
+	// CertManagerReleaseURL is the base URL of the cert-manager release artifacts.
+	CertManagerReleaseURL = "https://github.com/cert-manager/cert-manager/releases/download"
+	// LocalPathProvisionerURL is the base URL of the local-path-provisioner sources.
+	LocalPathProvisionerURL = "https://raw.githubusercontent.com/rancher/local-path-provisioner"
+	// addonReadyTimeout is the maximum time to wait for an add-on to become ready.
+	addonReadyTimeout = time.Minute * 5
+	// fetchTimeout is the maximum duration of a download on the local host,
+	// which includes reading the body to download binaries, such as kubectl.
+	fetchTimeout = time.Minute * 5
+)
+
+// fetchClient is the HTTP client used for downloads on the local host.
+// Unlike the default client, it aborts stalled downloads.
+var fetchClient = &http.Client{
+	Timeout: fetchTimeout,
+}
+
+// helmChart is a custom resource of the helm controller embedded in k3s.
+type helmChart struct {
+	APIVersion string           `yaml:"apiVersion"`
+	Kind       string           `yaml:"kind"`
+	Metadata   manifestMetadata `yaml:"metadata"`
+	Spec       helmChartSpec    `yaml:"spec"`
+}
+
+// manifestMetadata is the metadata of a Kubernetes object.
+type manifestMetadata struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+// helmChartSpec describes the chart to be deployed by the helm controller.
+type helmChartSpec struct {
+	Repo            string `yaml:"repo,omitempty"`
+	Chart           string `yaml:"chart"`
+	Version         string `yaml:"version,omitempty"`
+	TargetNamespace string `yaml:"targetNamespace,omitempty"`
+	CreateNamespace bool   `yaml:"createNamespace,omitempty"`
+	ValuesContent   string `yaml:"valuesContent,omitempty"`
+}
+
+// AddK3SHelmChart installs a Helm chart via the helm controller embedded in
+// k3s by writing a HelmChart resource to the manifests directory. The chart
+// is deployed to the specified namespace, which is created if necessary.
+func (client *Client) AddK3SHelmChart(namespace, name, repoURL, chartName, valuesYAML string) error {
+	return client.writeHelmChart(name, helmChartSpec{
+		Repo:            repoURL,
+		Chart:           chartName,
+		TargetNamespace: namespace,
+		CreateNamespace: true,
+		ValuesContent:   valuesYAML,
+	})
+}
+
+// EnableK3SMetricsServer installs the metrics-server chart and waits for
+// its deployment to become ready. Please note that k3s already bundles
+// the metrics-server, which should be disabled via "--disable" first.
+func (client *Client) EnableK3SMetricsServer() error {
+	if err := client.writeHelmChart("metrics-server", helmChartSpec{
+		Repo:            MetricsServerRepoURL,
+		Chart:           "metrics-server",
+		Version:         client.MetricsServerVersion,
+		TargetNamespace: "kube-system",
+	}); err != nil {
+		return err
+	}
+
+	return client.WaitForDeployment("kube-system", "metrics-server", addonReadyTimeout)
+}
+
+// SetK3SExtraManifest writes a manifest to the manifests directory of k3s,
+// which deploys it automatically and keeps it up-to-date. The name must
+// not contain the file extension.
+func (client *Client) SetK3SExtraManifest(name string, content []byte) error {
+	if err := validateManifestName(name); err != nil {
+		return err
+	}
+
+	client.Logger.Info().Str("manifest", name).Msg("Writing manifest")
+	return client.AtomicWriteFile(path.Join(K3SManifestsDir, name+".yaml"), content, 0600)
+}
+
+// DeleteK3SExtraManifest removes a manifest from the manifests directory of
+// k3s. Please note that k3s does not delete the deployed resources.
+func (client *Client) DeleteK3SExtraManifest(name string) error {
+	if err := validateManifestName(name); err != nil {
+		return err
+	}
+
+	manifestPath := path.Join(K3SManifestsDir, name+".yaml")
+	defer client.invalidateFile(manifestPath)
+
+	client.Logger.Info().Str("manifest", name).Msg("Deleting manifest")
+	return client.Do(Cmd{
+		Cmd: "sudo rm -f " + manifestPath,
+	})
+}
+
+// GetK3SExtraManifests returns the content of all manifests in the
+// manifests directory of k3s by file name, including the manifests
+// of the add-ons bundled with k3s.
+func (client *Client) GetK3SExtraManifests() (map[string][]byte, error) {
+	entries, err := client.ListDir(K3SManifestsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := make(map[string][]byte)
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry, ".yaml") && !strings.HasSuffix(entry, ".yml") {
+			continue
+		}
+
+		content, err := client.ReadFile(path.Join(K3SManifestsDir, entry))
+		if err != nil {
+			return nil, err
+		}
+		manifests[entry] = content
+	}
+
+	return manifests, nil
+}
+
+// InstallCertManager installs cert-manager of the specified version, such
+// as "v1.16.2", by applying the manifests of the GitHub release. It blocks
+// until all deployments of cert-manager are ready.
+func (client *Client) InstallCertManager(version string) error {
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+
+	// The CRDs are applied first to ensure that they are
+	// established before any custom resources are created.
+	for _, name := range []string{"cert-manager.crds.yaml", "cert-manager.yaml"} {
+		manifest, err := fetchURL(fmt.Sprintf("%s/%s/%s", CertManagerReleaseURL, version, name))
+		if err != nil {
+			return err
+		}
+
+		client.Logger.Info().Str("manifest", name).Str("version", version).Msg("Applying cert-manager manifest")
+		if err := client.ApplyManifest(manifest); err != nil {
+			return err
+		}
+	}
+
+	for _, deployment := range []string{"cert-manager", "cert-manager-cainjector", "cert-manager-webhook"} {
+		if err := client.WaitForDeployment("cert-manager", deployment, addonReadyTimeout); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// InstallLocalPathProvisioner installs the local-path-provisioner of the
+// specified version, such as "v0.0.30", and marks its storage class as the
+// default. Please note that the provisioner bundled with k3s should be
+// disabled via "--disable=local-storage" first.
+func (client *Client) InstallLocalPathProvisioner(version string) error {
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+
+	manifest, err := fetchURL(fmt.Sprintf("%s/%s/deploy/local-path-storage.yaml", LocalPathProvisionerURL, version))
+	if err != nil {
+		return err
+	}
+
+	client.Logger.Info().Str("version", version).Msg("Applying local-path-provisioner manifest")
+	if err := client.ApplyManifest(manifest); err != nil {
+		return err
+	}
+
+	if _, err := client.kubectl(`patch storageclass local-path -p '{"metadata":{"annotations":{"storageclass.kubernetes.io/is-default-class":"true"}}}'`); err != nil {
+		return err
+	}
+
+	return client.WaitForDeployment("local-path-storage", "local-path-provisioner", addonReadyTimeout)
+}
+
+// fetchURL downloads the content of a URL on the local host.
+func fetchURL(rawURL string) ([]byte, error) {
+	resp, err := fetchClient.Get(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download: %s: %s", rawURL, resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// writeHelmChart writes a HelmChart resource to the manifests directory.
+func (client *Client) writeHelmChart(name string, spec helmChartSpec) error {
+	if err := validateManifestName(name); err != nil {
+		return err
+	}
+
+	manifest, err := yaml.Marshal(&helmChart{
+		APIVersion: "helm.cattle.io/v1",
+		Kind:       "HelmChart",
+		Metadata: manifestMetadata{
+			Name: name,
+			// The helm controller of k3s watches the "kube-system" namespace.
+			Namespace: "kube-system",
+		},
+		Spec: spec,
+	})
+	if err != nil {
+		return err
+	}
+
+	client.Logger.Info().Str("chart", spec.Chart).Msg("Adding Helm chart")
+	return client.AtomicWriteFile(path.Join(K3SManifestsDir, name+".yaml"), manifest, 0600)
+}
+
+// validateManifestName returns an error if the name of a manifest
+// would point outside of the manifests directory of k3s.
+func validateManifestName(name string) error {
+	if name == "" || strings.ContainsRune(name, '/') || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid manifest name: %s", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package sshx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EnsureNFSMount mounts an NFS export at the mount point unless it is
+// already mounted. An entry is also added to "/etc/fstab" to persist
+// the mount across reboots. An error is returned if a different file
+// system is mounted at or configured for the mount point.
+func (client *Client) EnsureNFSMount(server, remotePath, localMountPoint string) error {
+	source := fmt.Sprintf("%s:%s", server, remotePath)
+	logger := client.Logger.With().Str("source", source).Str("target", localMountPoint).Logger()
+
+	fstab, err := client.ReadFile("/etc/fstab")
+	if err != nil {
+		return err
+	}
+
+	persisted := false
+	for _, line := range strings.Split(string(fstab), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || fields[1] != localMountPoint {
+			continue
+		}
+
+		if !sameNFSSource(fields[0], source) {
+			return fmt.Errorf("fstab entry for %s has different source: %s", localMountPoint, fields[0])
+		}
+		persisted = true
+	}
+
+	// The command fails if nothing is mounted at the mount point.
+	mounted, err := client.output(Cmd{
+		Cmd: "findmnt -n -o SOURCE " + localMountPoint,
+	})
+	if err != nil {
+		logger.Info().Msg("Mounting NFS export")
+		if err := client.Do(Cmd{
+			Cmd: fmt.Sprintf("sudo mkdir -p %s && sudo mount -t nfs %s %s", localMountPoint, source, localMountPoint),
+		}); err != nil {
+			return err
+		}
+	} else if !sameNFSSource(mounted, source) {
+		return fmt.Errorf("mount point %s has different source: %s", localMountPoint, mounted)
+	}
+
+	if persisted {
+		return nil
+	}
+
+	// Rebuild the content instead of appending to ensure
+	// that the entry is not glued onto the last line.
+	content := string(fstab)
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	content += fmt.Sprintf("%s %s nfs defaults,_netdev 0 0\n", source, localMountPoint)
+
+	logger.Info().Msg("Persisting NFS mount")
+	return client.AtomicWriteFile("/etc/fstab", []byte(content), 0644)
+}
+
+// sameNFSSource reports whether two NFS sources in the format of
+// "server:/path" are equal, ignoring trailing slashes of the path.
+func sameNFSSource(a, b string) bool {
+	return strings.TrimRight(a, "/") == strings.TrimRight(b, "/")
+}
@@ -0,0 +1,92 @@
+package sshx
+
+import (
+	"encoding/json"
+	"net"
+	"slices"
+)
+
+// NetworkInterface describes a network interface of the remote host.
+type NetworkInterface struct {
+	Name         string
+	HardwareAddr string
+	Addresses    []net.IPNet
+	MTU          int
+	Up           bool
+}
+
+// ipLink is the JSON output of "ip -j link".
+type ipLink struct {
+	Name    string   `json:"ifname"`
+	Flags   []string `json:"flags"`
+	MTU     int      `json:"mtu"`
+	Address string   `json:"address"`
+}
+
+// ipAddr is the JSON output of "ip -j addr".
+type ipAddr struct {
+	Name     string `json:"ifname"`
+	AddrInfo []struct {
+		Local     string `json:"local"`
+		PrefixLen int    `json:"prefixlen"`
+	} `json:"addr_info"`
+}
+
+// GetNetworkInterfaces returns the network interfaces of the remote host
+// including their addresses. This helps to select the correct node IP if
+// a node has multiple interfaces.
+func (client *Client) GetNetworkInterfaces() ([]NetworkInterface, error) {
+	linkOutput, err := client.output(Cmd{Cmd: "ip -j link"})
+	if err != nil {
+		return nil, err
+	}
+
+	var links []ipLink
+	if err := json.Unmarshal([]byte(linkOutput), &links); err != nil {
+		return nil, err
+	}
+
+	addrOutput, err := client.output(Cmd{Cmd: "ip -j addr"})
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []ipAddr
+	if err := json.Unmarshal([]byte(addrOutput), &addrs); err != nil {
+		return nil, err
+	}
+
+	addresses := make(map[string][]net.IPNet)
+	for _, addr := range addrs {
+		for _, info := range addr.AddrInfo {
+			ip := net.ParseIP(info.Local)
+			if ip == nil {
+				continue
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+
+			addresses[addr.Name] = append(addresses[addr.Name], net.IPNet{
+				IP:   ip,
+				Mask: net.CIDRMask(info.PrefixLen, bits),
+			})
+		}
+	}
+
+	interfaces := make([]NetworkInterface, 0, len(links))
+	for _, link := range links {
+		interfaces = append(interfaces, NetworkInterface{
+			Name:         link.Name,
+			HardwareAddr: link.Address,
+			Addresses:    addresses[link.Name],
+			MTU:          link.MTU,
+			Up:           slices.Contains(link.Flags, "UP"),
+		})
+	}
+
+	return interfaces, nil
+}
@@ -0,0 +1,213 @@
+package sshx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NodeChange describes a single difference between two node snapshots.
+type NodeChange struct {
+	// Kind is the kind of the change, such as "service" or "config".
+	Kind string `json:"kind"`
+	// Key identifies the changed value, such as the name of a service.
+	Key string `json:"key"`
+	// Before is the previous value, which is empty if the value was added.
+	Before string `json:"before,omitempty"`
+	// After is the current value, which is empty if the value was removed.
+	After string `json:"after,omitempty"`
+	// Unexpected reports whether the change is unlikely to be caused by
+	// an intended operation, such as a service that stopped running.
+	Unexpected bool `json:"unexpected"`
+}
+
+// NodeDiff describes the differences between two node snapshots. It may be
+// formatted as text via String or as JSON via the encoding/json package.
+type NodeDiff struct {
+	Before  time.Time    `json:"before"`
+	After   time.Time    `json:"after"`
+	Changes []NodeChange `json:"changes"`
+	// MemoryAvailableDelta is the change of the available memory in bytes.
+	MemoryAvailableDelta int64 `json:"memoryAvailableDelta"`
+	// DiskAvailableDelta maps mount points to the change of the available
+	// disk space in bytes. Mount points that were removed are omitted.
+	DiskAvailableDelta map[string]int64 `json:"diskAvailableDelta,omitempty"`
+	// LoadAverageDelta is the change of the 1, 5 and 15 minute load averages.
+	LoadAverageDelta [3]float64 `json:"loadAverageDelta"`
+}
+
+// DiffNodeSnapshots computes the differences between two snapshots of a
+// node, which allows to detect configuration drift caused by an operation.
+// Changes of the system information, services that stopped running as
+// well as closed ports and removed mount points are marked as unexpected.
+func DiffNodeSnapshots(before, after *NodeSnapshot) (*NodeDiff, error) {
+	if before == nil || after == nil {
+		return nil, errors.New("missing node snapshot")
+	}
+
+	diff := &NodeDiff{
+		Before:             before.Time,
+		After:              after.Time,
+		DiskAvailableDelta: make(map[string]int64),
+	}
+
+	change := func(kind, key, from, to string, unexpected bool) {
+		if from != to {
+			diff.Changes = append(diff.Changes, NodeChange{
+				Kind:       kind,
+				Key:        key,
+				Before:     from,
+				After:      to,
+				Unexpected: unexpected,
+			})
+		}
+	}
+
+	change("system", "hostname", before.SystemInfo.Hostname, after.SystemInfo.Hostname, true)
+	change("system", "arch", before.SystemInfo.Arch, after.SystemInfo.Arch, true)
+	change("system", "kernel", before.SystemInfo.Kernel, after.SystemInfo.Kernel, true)
+	change("system", "os", before.SystemInfo.OS, after.SystemInfo.OS, true)
+	change("k3s", "version", before.K3SVersion, after.K3SVersion, false)
+
+	for _, service := range sortedKeys(before.ServiceStatuses, after.ServiceStatuses) {
+		from, to := before.ServiceStatuses[service], after.ServiceStatuses[service]
+		change("service", service, from, to, from == "active" && to != "active")
+	}
+
+	beforePorts := make(map[string]string, len(before.OpenPorts))
+	for _, port := range before.OpenPorts {
+		beforePorts[port] = "listening"
+	}
+	afterPorts := make(map[string]string, len(after.OpenPorts))
+	for _, port := range after.OpenPorts {
+		afterPorts[port] = "listening"
+	}
+	for _, port := range sortedKeys(beforePorts, afterPorts) {
+		change("port", port, beforePorts[port], afterPorts[port], afterPorts[port] == "")
+	}
+
+	beforeConfig := flattenConfig(before.K3SConfig, "")
+	afterConfig := flattenConfig(after.K3SConfig, "")
+	for _, key := range diffKeys(beforeConfig, afterConfig) {
+		from, err := formatConfigValue(beforeConfig[key])
+		if err != nil {
+			return nil, err
+		}
+		to, err := formatConfigValue(afterConfig[key])
+		if err != nil {
+			return nil, err
+		}
+		change("config", key, from, to, false)
+	}
+
+	beforeDisks := make(map[string]DiskUsage, len(before.DiskUsage))
+	for _, disk := range before.DiskUsage {
+		beforeDisks[disk.MountPoint] = disk
+	}
+	afterDisks := make(map[string]bool, len(after.DiskUsage))
+	for _, disk := range after.DiskUsage {
+		afterDisks[disk.MountPoint] = true
+
+		if old, ok := beforeDisks[disk.MountPoint]; ok {
+			diff.DiskAvailableDelta[disk.MountPoint] = int64(disk.AvailableBytes) - int64(old.AvailableBytes)
+		} else {
+			change("disk", disk.MountPoint, "", "mounted", false)
+		}
+	}
+	for _, disk := range before.DiskUsage {
+		if !afterDisks[disk.MountPoint] {
+			change("disk", disk.MountPoint, "mounted", "", true)
+		}
+	}
+
+	diff.MemoryAvailableDelta = int64(after.MemoryUsage.AvailableBytes) - int64(before.MemoryUsage.AvailableBytes)
+	for i := range diff.LoadAverageDelta {
+		diff.LoadAverageDelta[i] = after.LoadAverage[i] - before.LoadAverage[i]
+	}
+
+	return diff, nil
+}
+
+// Unexpected returns the changes that are marked as unexpected.
+func (diff *NodeDiff) Unexpected() []NodeChange {
+	var unexpected []NodeChange
+
+	for _, change := range diff.Changes {
+		if change.Unexpected {
+			unexpected = append(unexpected, change)
+		}
+	}
+
+	return unexpected
+}
+
+// String formats the diff as human-readable text. Unexpected
+// changes are prefixed with an exclamation mark.
+func (diff *NodeDiff) String() string {
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "Node diff from %s to %s\n", diff.Before.Format(time.RFC3339), diff.After.Format(time.RFC3339))
+
+	for _, change := range diff.Changes {
+		marker := " "
+		if change.Unexpected {
+			marker = "!"
+		}
+
+		fmt.Fprintf(&builder, "%s %s %s: %s -> %s\n", marker, change.Kind, change.Key, orNone(change.Before), orNone(change.After))
+	}
+
+	fmt.Fprintf(&builder, "Memory available: %+d bytes\n", diff.MemoryAvailableDelta)
+	for _, mountPoint := range sortedKeys(diff.DiskAvailableDelta) {
+		fmt.Fprintf(&builder, "Disk available on %s: %+d bytes\n", mountPoint, diff.DiskAvailableDelta[mountPoint])
+	}
+	fmt.Fprintf(&builder, "Load average: %+.2f %+.2f %+.2f\n", diff.LoadAverageDelta[0], diff.LoadAverageDelta[1], diff.LoadAverageDelta[2])
+
+	return builder.String()
+}
+
+// formatConfigValue formats a value of the k3s configuration as JSON,
+// which distinguishes lists from strings. Missing values are empty.
+func formatConfigValue(value interface{}) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+
+	content, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	return string(content), nil
+}
+
+// orNone returns "<none>" for empty values.
+func orNone(value string) string {
+	if value == "" {
+		return "<none>"
+	}
+
+	return value
+}
+
+// sortedKeys returns the sorted union of the keys of the maps.
+func sortedKeys[V any](maps ...map[string]V) []string {
+	seen := make(map[string]bool)
+	var keys []string
+
+	for _, m := range maps {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,311 @@
+package sshx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	// sysctlConfigPath is the file used to persist sysctl settings.
+	sysctlConfigPath = "/etc/sysctl.d/90-k3se.conf"
+)
+
+// NodeSpec describes the desired state of a node. Applying the spec only
+// changes what differs from the actual state, similar to "kubectl apply".
+type NodeSpec struct {
+	// Hostname is the desired hostname of the node.
+	Hostname string
+	// Labels are the Kubernetes labels of the node.
+	Labels map[string]string
+	// Taints are the Kubernetes taints of the node in
+	// the format "key=value:Effect" or "key:Effect".
+	Taints []string
+	// SysctlSettings are kernel parameters, which are persisted.
+	SysctlSettings map[string]string
+	// RequiredPackages are packages that must be installed.
+	RequiredPackages []string
+	// K3SVersion is the desired version of k3s, such as "v1.31.2+k3s1".
+	K3SVersion string
+}
+
+// nodeTaint is a taint of a Kubernetes node.
+type nodeTaint struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Effect string `json:"effect"`
+}
+
+// String formats the taint as expected by "kubectl taint".
+func (t nodeTaint) String() string {
+	if t.Value == "" {
+		return fmt.Sprintf("%s:%s", t.Key, t.Effect)
+	}
+
+	return fmt.Sprintf("%s=%s:%s", t.Key, t.Value, t.Effect)
+}
+
+// Apply compares the desired state with the actual state of the node and
+// applies the differences. Unset fields are ignored. Labels and taints are
+// managed via the kubectl of k3s, which requires the node to be a server.
+func (spec *NodeSpec) Apply(client *Client) (bool, error) {
+	changed := false
+
+	steps := []func(*Client) (bool, error){
+		spec.applyHostname,
+		spec.applySysctlSettings,
+		spec.applyRequiredPackages,
+		spec.applyK3SVersion,
+		spec.applyLabels,
+		spec.applyTaints,
+	}
+
+	for _, step := range steps {
+		stepChanged, err := step(client)
+		if err != nil {
+			return changed, err
+		}
+		changed = changed || stepChanged
+	}
+
+	return changed, nil
+}
+
+// applyHostname sets the hostname of the node.
+func (spec *NodeSpec) applyHostname(client *Client) (bool, error) {
+	if spec.Hostname == "" {
+		return false, nil
+	}
+
+	hostname, err := client.GetHostname()
+	if err != nil || hostname == spec.Hostname {
+		return false, err
+	}
+
+	client.Logger.Info().Str("hostname", spec.Hostname).Msg("Setting hostname")
+	return true, client.Do(Cmd{
+		Cmd: "sudo hostnamectl set-hostname " + spec.Hostname,
+	})
+}
+
+// applySysctlSettings sets and persists the kernel parameters.
+func (spec *NodeSpec) applySysctlSettings(client *Client) (bool, error) {
+	if len(spec.SysctlSettings) == 0 {
+		return false, nil
+	}
+
+	keys := make([]string, 0, len(spec.SysctlSettings))
+	for key := range spec.SysctlSettings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	changed := false
+	config := new(strings.Builder)
+	for _, key := range keys {
+		value := spec.SysctlSettings[key]
+		fmt.Fprintf(config, "%s = %s\n", key, value)
+
+		actual, err := client.output(Cmd{
+			Cmd: "sysctl -n " + key,
+		})
+		if err != nil {
+			return changed, err
+		}
+
+		// Multi-value parameters are separated by tabs.
+		if strings.Join(strings.Fields(actual), " ") == strings.Join(strings.Fields(value), " ") {
+			continue
+		}
+
+		client.Logger.Info().Str("key", key).Str("value", value).Msg("Setting kernel parameter")
+		if err := client.Do(Cmd{
+			Cmd: fmt.Sprintf(`sudo sysctl -w %s="%s"`, key, value),
+		}); err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+
+	// The file is reconciled separately, as parameters may have
+	// been set at runtime without being persisted.
+	persisted, err := client.ReadFile(sysctlConfigPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return changed, err
+	}
+	if string(persisted) == config.String() {
+		return changed, nil
+	}
+
+	client.Logger.Info().Str("path", sysctlConfigPath).Msg("Persisting kernel parameters")
+	return true, client.AtomicWriteFile(sysctlConfigPath, []byte(config.String()), 0644)
+}
+
+// applyRequiredPackages installs all missing packages.
+func (spec *NodeSpec) applyRequiredPackages(client *Client) (bool, error) {
+	if len(spec.RequiredPackages) == 0 {
+		return false, nil
+	}
+
+	manager, err := client.packageManager()
+	if err != nil {
+		return false, err
+	}
+
+	var missing []string
+	for _, pkg := range spec.RequiredPackages {
+		if err := client.Do(Cmd{
+			Cmd: manager.query + " " + pkg,
+		}); err != nil {
+			missing = append(missing, pkg)
+		}
+	}
+
+	if len(missing) == 0 {
+		return false, nil
+	}
+
+	client.Logger.Info().Strs("packages", missing).Msg("Installing packages")
+	return true, client.Do(Cmd{
+		Cmd: manager.install + " " + strings.Join(missing, " "),
+	})
+}
+
+// applyK3SVersion replaces the k3s binary if the version differs. If k3s
+// is missing, it is installed via the installation script, which uses the
+// k3s configuration file of the node and installs a server by default.
+func (spec *NodeSpec) applyK3SVersion(client *Client) (bool, error) {
+	if spec.K3SVersion == "" {
+		return false, nil
+	}
+
+	version, err := client.GetK3SVersion()
+	if errors.Is(err, ErrK3SNotInstalled) {
+		client.Logger.Info().Str("version", spec.K3SVersion).Msg("Running installation script")
+		_, err := client.output(Cmd{
+			Cmd: fmt.Sprintf("set -o pipefail && curl -sfL %s | sudo INSTALL_K3S_VERSION=%s sh -", K3SInstallScriptURL, spec.K3SVersion),
+		})
+		return err == nil, err
+	}
+	if err != nil || version == spec.K3SVersion {
+		return false, err
+	}
+
+	if err := client.InstallK3SBinary(spec.K3SVersion); err != nil {
+		return false, err
+	}
+
+	return true, client.ServiceRestart(client.k3sService())
+}
+
+// applyLabels sets the Kubernetes labels of the node.
+func (spec *NodeSpec) applyLabels(client *Client) (bool, error) {
+	if len(spec.Labels) == 0 {
+		return false, nil
+	}
+
+	name, err := client.GetK3SAgentNodeName()
+	if err != nil {
+		return false, err
+	}
+
+	labels, err := client.GetK3SNodeLabels(name)
+	if err != nil {
+		return false, err
+	}
+
+	var pending []string
+	for key, value := range spec.Labels {
+		if actual, ok := labels[key]; !ok || actual != value {
+			pending = append(pending, fmt.Sprintf("%s=%s", key, value))
+		}
+	}
+
+	if len(pending) == 0 {
+		return false, nil
+	}
+	sort.Strings(pending)
+
+	client.Logger.Info().Strs("labels", pending).Msg("Labeling node")
+	_, err = client.kubectl(fmt.Sprintf("label node %s --overwrite %s", name, strings.Join(pending, " ")))
+	return true, err
+}
+
+// applyTaints adds the missing Kubernetes taints to the node.
+func (spec *NodeSpec) applyTaints(client *Client) (bool, error) {
+	if len(spec.Taints) == 0 {
+		return false, nil
+	}
+
+	name, err := client.GetK3SAgentNodeName()
+	if err != nil {
+		return false, err
+	}
+
+	output, err := client.kubectl(fmt.Sprintf("get node %s -o jsonpath='{.spec.taints}'", name))
+	if err != nil {
+		return false, err
+	}
+
+	var taints []nodeTaint
+	if output != "" {
+		if err := json.Unmarshal([]byte(output), &taints); err != nil {
+			return false, err
+		}
+	}
+
+	existing := make(map[string]bool)
+	for _, taint := range taints {
+		existing[taint.String()] = true
+	}
+
+	var pending []string
+	for _, taint := range spec.Taints {
+		if !existing[taint] {
+			pending = append(pending, taint)
+		}
+	}
+
+	if len(pending) == 0 {
+		return false, nil
+	}
+
+	client.Logger.Info().Strs("taints", pending).Msg("Tainting node")
+	_, err = client.kubectl(fmt.Sprintf("taint node %s --overwrite %s", name, strings.Join(pending, " ")))
+	return true, err
+}
+
+// packageManager describes the commands of a package manager.
+type packageManager struct {
+	query   string
+	install string
+	remove  string
+}
+
+// packageManagers are the supported package managers by binary name.
+var packageManagers = []struct {
+	binary  string
+	manager packageManager
+}{
+	{"apt-get", packageManager{query: "dpkg -s", install: "sudo DEBIAN_FRONTEND=noninteractive apt-get install -y", remove: "sudo DEBIAN_FRONTEND=noninteractive apt-get remove -y"}},
+	{"dnf", packageManager{query: "rpm -q", install: "sudo dnf install -y", remove: "sudo dnf remove -y"}},
+	{"yum", packageManager{query: "rpm -q", install: "sudo yum install -y", remove: "sudo yum remove -y"}},
+	{"zypper", packageManager{query: "rpm -q", install: "sudo zypper --non-interactive install", remove: "sudo zypper --non-interactive remove"}},
+	{"apk", packageManager{query: "apk info -e", install: "sudo apk add", remove: "sudo apk del"}},
+}
+
+// packageManager detects the package manager of the remote host.
+func (client *Client) packageManager() (*packageManager, error) {
+	for _, candidate := range packageManagers {
+		if _, err := client.output(Cmd{
+			Cmd: "command -v " + candidate.binary,
+		}); err == nil {
+			return &candidate.manager, nil
+		}
+	}
+
+	return nil, errors.New("no supported package manager found")
+}
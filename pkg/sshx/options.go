@@ -1,6 +1,7 @@
 package sshx
 
 import (
+	"errors"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -12,6 +13,23 @@ type Options struct {
 	Proxy        *Client
 	Timeout      time.Duration
 	STFPDisabled bool
+
+	// MetricsServerVersion pins the version of the metrics-server
+	// chart. The latest version is installed if it is empty.
+	MetricsServerVersion string
+	// AutoRestart restarts k3s after its configuration has been changed.
+	AutoRestart bool
+	// Concurrency limits the number of concurrent connection attempts.
+	Concurrency int
+	// ImagePullTimeout limits the duration of a container image pull.
+	ImagePullTimeout time.Duration
+	// KeepRemote keeps the remote copy of exported files, such as
+	// etcd snapshots, after they have been downloaded.
+	KeepRemote bool
+	// AutoEnable enables systemd units after their unit file has been written.
+	AutoEnable bool
+
+	fileCache *fileCache
 }
 
 // Option applies a configuration option
@@ -35,9 +53,12 @@ func GetDefaultOptions() *Options {
 
 	return &Options{
 		Proxy:        nil,
-		Timeout:      time.Second * 5,
+		Timeout:      DefaultTimeout,
 		Logger:       &logger,
 		STFPDisabled: false,
+		Concurrency:  16,
+
+		ImagePullTimeout: time.Minute * 10,
 	}
 }
 
@@ -73,3 +94,71 @@ func WithSTFPDisabled() Option {
 		return nil
 	}
 }
+
+// WithMetricsServerVersion allows to pin the version of the metrics-server.
+func WithMetricsServerVersion(version string) Option {
+	return func(options *Options) error {
+		options.MetricsServerVersion = version
+		return nil
+	}
+}
+
+// WithAutoRestart allows to restart k3s automatically
+// after its configuration has been changed.
+func WithAutoRestart() Option {
+	return func(options *Options) error {
+		options.AutoRestart = true
+		return nil
+	}
+}
+
+// WithFileCache allows to cache the content of remote files read via
+// ReadFile. The cache holds up to maxEntries files for the duration
+// of the ttl. Files written via the client are removed from the cache.
+func WithFileCache(maxEntries int, ttl time.Duration) Option {
+	return func(options *Options) error {
+		if maxEntries <= 0 {
+			return errors.New("file cache must hold at least one entry")
+		}
+
+		options.fileCache = newFileCache(maxEntries, ttl)
+		return nil
+	}
+}
+
+// WithConcurrency allows to limit the number of concurrent connection attempts.
+func WithConcurrency(concurrency int) Option {
+	return func(options *Options) error {
+		if concurrency <= 0 {
+			return errors.New("concurrency must be positive")
+		}
+
+		options.Concurrency = concurrency
+		return nil
+	}
+}
+
+// WithImagePullTimeout allows to set a custom timeout for container image pulls.
+func WithImagePullTimeout(timeout time.Duration) Option {
+	return func(options *Options) error {
+		options.ImagePullTimeout = timeout
+		return nil
+	}
+}
+
+// WithKeepRemote allows to keep the remote copy of exported files.
+func WithKeepRemote() Option {
+	return func(options *Options) error {
+		options.KeepRemote = true
+		return nil
+	}
+}
+
+// WithAutoEnable allows to enable systemd units
+// automatically after their unit file has been written.
+func WithAutoEnable() Option {
+	return func(options *Options) error {
+		options.AutoEnable = true
+		return nil
+	}
+}
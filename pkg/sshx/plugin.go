@@ -0,0 +1,112 @@
+package sshx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Plugin transforms a command before it is executed on the remote host.
+// Plugins are applied in the order in which they were registered.
+type Plugin interface {
+	Transform(cmd Cmd) (Cmd, error)
+}
+
+// RegisterPlugin registers a plugin that is applied to every command
+// executed via Do. Please note that this function is not safe to be
+// called while commands are executed concurrently.
+func (client *Client) RegisterPlugin(plugin Plugin) {
+	client.plugins = append(client.plugins, plugin)
+}
+
+// applyPlugins applies all registered plugins to the command.
+func (client *Client) applyPlugins(command Cmd) (Cmd, error) {
+	for _, plugin := range client.plugins {
+		var err error
+		if command, err = plugin.Transform(command); err != nil {
+			return command, err
+		}
+	}
+
+	return command, nil
+}
+
+// TimestampStdout is a plugin that prepends a timestamp to every line
+// written to the standard output of a command.
+type TimestampStdout struct{}
+
+// Transform wraps the standard output of the command.
+func (TimestampStdout) Transform(cmd Cmd) (Cmd, error) {
+	if cmd.Stdout != nil {
+		cmd.Stdout = &timestampWriter{
+			out:       cmd.Stdout,
+			lineStart: true,
+		}
+	}
+
+	return cmd, nil
+}
+
+// CommandLogger is a plugin that logs every command before it is executed.
+type CommandLogger struct {
+	Logger *zerolog.Logger
+}
+
+// Transform logs the command without modifying it.
+func (p CommandLogger) Transform(cmd Cmd) (Cmd, error) {
+	if p.Logger != nil {
+		p.Logger.Debug().Str("cmd", cmd.String()).Msg("Executing command")
+	}
+
+	return cmd, nil
+}
+
+// ExitCodeReporter is a plugin that writes the exit code of every
+// command to its standard error once the command has terminated.
+// The exit code of the command itself is preserved.
+type ExitCodeReporter struct{}
+
+// Transform appends a report of the exit code to the command. The shell
+// of the command is not changed, as commands may rely on the login shell
+// of the user, such as "set -o pipefail", which is not supported by "sh".
+func (ExitCodeReporter) Transform(cmd Cmd) (Cmd, error) {
+	cmd.Cmd = fmt.Sprintf(`%s; code=$?; echo "exit code: $code" >&2; exit $code`, cmd.Cmd)
+
+	return cmd, nil
+}
+
+// timestampWriter prefixes each line with the current time.
+type timestampWriter struct {
+	out       io.Writer
+	lineStart bool
+}
+
+// Write writes the data to the underlying writer and prefixes
+// every new line with a timestamp.
+func (w *timestampWriter) Write(raw []byte) (int, error) {
+	remaining := raw
+	for len(remaining) > 0 {
+		if w.lineStart {
+			if _, err := fmt.Fprintf(w.out, "%s ", time.Now().Format(time.RFC3339)); err != nil {
+				return 0, err
+			}
+			w.lineStart = false
+		}
+
+		chunk := remaining
+		if i := bytes.IndexByte(remaining, '\n'); i >= 0 {
+			chunk = remaining[:i+1]
+			w.lineStart = true
+		}
+
+		if _, err := w.out.Write(chunk); err != nil {
+			return 0, err
+		}
+		remaining = remaining[len(chunk):]
+	}
+
+	return len(raw), nil
+}
@@ -0,0 +1,59 @@
+package sshx
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestExitCodeReporterPreservesQuotes(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  Cmd
+	}{
+		{
+			name: "plain",
+			cmd:  Cmd{Cmd: `printf '%s\n' 'it'"'"'s quoted'; false`},
+		},
+		{
+			name: "env",
+			cmd: Cmd{
+				Cmd: `printf '%s\n' "$GREETING"' quoted'; false`,
+				Env: map[string]string{"GREETING": "it's"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := ExitCodeReporter{}.Transform(tt.cmd)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if cmd.Shell != tt.cmd.Shell {
+				t.Errorf("shell changed from %v to %v", tt.cmd.Shell, cmd.Shell)
+			}
+
+			var stdout, stderr bytes.Buffer
+			local := exec.Command("sh", "-c", cmd.String())
+			local.Stdout = &stdout
+			local.Stderr = &stderr
+
+			err = local.Run()
+			exitErr, ok := err.(*exec.ExitError)
+			if !ok || exitErr.ExitCode() != 1 {
+				t.Fatalf("expected exit code 1, got: %v (stderr: %s)", err, stderr.String())
+			}
+
+			if got := strings.TrimSpace(stdout.String()); got != "it's quoted" {
+				t.Errorf("unexpected stdout: %q", got)
+			}
+
+			if got := strings.TrimSpace(stderr.String()); got != "exit code: 1" {
+				t.Errorf("unexpected stderr: %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package sshx
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// permanentError marks an error of a check that must not be retried.
+type permanentError struct {
+	err error
+}
+
+// Error returns the message of the wrapped error.
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps an error of a check to make PollUntil return it
+// immediately instead of retrying, such as if k3s is not installed.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// PollUntil calls the check immediately and then at every interval until
+// it reports success without an error. Errors of the check are treated
+// as transient unless they are wrapped via Permanent, in which case the
+// wrapped error is returned. Once the timeout expires, ErrPollTimeout is
+// returned wrapping the last error of the check, if any.
+func (client *Client) PollUntil(check func(*Client) (bool, error), interval, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		done, err := check(client)
+		if done && err == nil {
+			return nil
+		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("%w after %s: %w", ErrPollTimeout, timeout, err)
+			}
+			return fmt.Errorf("%w after %s", ErrPollTimeout, timeout)
+		}
+
+		time.Sleep(interval)
+	}
+}
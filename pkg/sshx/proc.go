@@ -0,0 +1,388 @@
+package sshx
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// cpuSampleInterval is the interval between the two samples of
+	// "/proc/stat" that are used to compute the current CPU usage.
+	cpuSampleInterval = time.Second
+)
+
+// ResourceUsage describes the current resource usage of the remote host.
+type ResourceUsage struct {
+	// CPUIdlePercent is the percentage of time the CPUs were idle.
+	CPUIdlePercent float64
+	// CPUUsagePercent is the percentage of time the CPUs were busy.
+	CPUUsagePercent float64
+	// K3SCPUUsagePercent is the percentage of the CPU time used by the
+	// k3s process. It is zero if the k3s service is not running.
+	K3SCPUUsagePercent float64
+	// MemoryTotalBytes is the total amount of usable memory.
+	MemoryTotalBytes uint64
+	// MemoryAvailableBytes is the amount of memory available
+	// for new workloads without swapping.
+	MemoryAvailableBytes uint64
+}
+
+// cpuTimes holds the aggregated CPU times of "/proc/stat" in jiffies.
+type cpuTimes struct {
+	idle  uint64
+	total uint64
+}
+
+// GetResourceUsage samples the CPU and memory usage of the remote host.
+// The CPU usage is computed from two samples of "/proc/stat", which is
+// why this function blocks for a short amount of time. This allows to
+// postpone disruptive operations, such as upgrades, on busy hosts.
+func (client *Client) GetResourceUsage() (*ResourceUsage, error) {
+	// The CPU usage of k3s is optional, as k3s may not be running.
+	pid, err := client.GetSystemdServicePID(client.k3sService())
+	if err != nil {
+		pid = 0
+	}
+
+	first, err := client.readCPUTimes()
+	if err != nil {
+		return nil, err
+	}
+
+	var firstProc uint64
+	if pid != 0 {
+		if firstProc, err = client.readProcCPUTime(pid); err != nil {
+			return nil, err
+		}
+	}
+
+	time.Sleep(cpuSampleInterval)
+
+	second, err := client.readCPUTimes()
+	if err != nil {
+		return nil, err
+	}
+
+	var secondProc uint64
+	if pid != 0 {
+		if secondProc, err = client.readProcCPUTime(pid); err != nil {
+			return nil, err
+		}
+	}
+
+	meminfo, err := client.readMeminfo()
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &ResourceUsage{
+		CPUIdlePercent:       100,
+		MemoryTotalBytes:     meminfo["MemTotal"],
+		MemoryAvailableBytes: meminfo["MemAvailable"],
+	}
+
+	if total := second.total - first.total; total > 0 {
+		usage.CPUIdlePercent = float64(second.idle-first.idle) / float64(total) * 100
+		if pid != 0 && secondProc >= firstProc {
+			usage.K3SCPUUsagePercent = float64(secondProc-firstProc) / float64(total) * 100
+		}
+	}
+	usage.CPUUsagePercent = 100 - usage.CPUIdlePercent
+
+	return usage, nil
+}
+
+// readProcCPUTime returns the user and system time of
+// a process from "/proc/<pid>/stat" in jiffies.
+func (client *Client) readProcCPUTime(pid int) (uint64, error) {
+	content, err := client.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	// The name of the process is enclosed in parentheses and may
+	// contain spaces, so the fields are parsed after the last one.
+	stat := string(content)
+	fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("malformed process stat: %d", pid)
+	}
+
+	// The fields "utime" and "stime" are the 14th and 15th column.
+	var total uint64
+	for _, field := range fields[11:13] {
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		total += value
+	}
+
+	return total, nil
+}
+
+// readCPUTimes reads the aggregated CPU times from "/proc/stat".
+func (client *Client) readCPUTimes() (*cpuTimes, error) {
+	content, err := client.ReadFile("/proc/stat")
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+
+		times := new(cpuTimes)
+		for i, field := range fields[1:] {
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+
+			times.total += value
+			// The fourth and fifth column are "idle" and "iowait".
+			if i == 3 || i == 4 {
+				times.idle += value
+			}
+		}
+
+		return times, nil
+	}
+
+	return nil, fmt.Errorf("no aggregated cpu times found in /proc/stat")
+}
+
+// readMeminfo parses "/proc/meminfo" into a map of values in bytes.
+func (client *Client) readMeminfo() (map[string]uint64, error) {
+	return client.readProcFile("/proc/meminfo")
+}
+
+// readProcFile parses a file of "key: value [unit]" lines into a map.
+// Values in kibibytes are converted to bytes and non-numeric values
+// are skipped.
+func (client *Client) readProcFile(remotePath string) (map[string]uint64, error) {
+	content, err := client.ReadFile(remotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]uint64)
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+
+		amount, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			// Some fields, such as "State", are not numeric.
+			continue
+		}
+
+		// Most values are reported in kibibytes.
+		if len(fields) > 1 && fields[1] == "kB" {
+			amount *= 1024
+		}
+
+		values[key] = amount
+	}
+
+	return values, nil
+}
+
+// ProcMetrics describes the resource usage of a single process.
+type ProcMetrics struct {
+	// PID is the ID of the process.
+	PID int
+	// ResidentBytes is the resident set size of the process.
+	ResidentBytes uint64
+	// Threads is the number of threads of the process.
+	Threads uint64
+	// ReadBytes is the number of bytes read from storage.
+	ReadBytes uint64
+	// WriteBytes is the number of bytes written to storage.
+	WriteBytes uint64
+}
+
+// GetK3SProcMetrics reads the memory and I/O statistics of the k3s process
+// from "/proc". If the PID is 0, the main PID of the k3s service is used.
+func (client *Client) GetK3SProcMetrics(pid int) (*ProcMetrics, error) {
+	if pid == 0 {
+		var err error
+		if pid, err = client.GetSystemdServicePID(client.k3sService()); err != nil {
+			return nil, err
+		}
+	}
+
+	status, err := client.readProcFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	io, err := client.readProcFile(fmt.Sprintf("/proc/%d/io", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProcMetrics{
+		PID:           pid,
+		ResidentBytes: status["VmRSS"],
+		Threads:       status["Threads"],
+		ReadBytes:     io["read_bytes"],
+		WriteBytes:    io["write_bytes"],
+	}, nil
+}
+
+// GetMemoryPressure returns the ratio of used memory between 0 and 1,
+// which is computed from "MemTotal" and "MemAvailable" of "/proc/meminfo".
+func (client *Client) GetMemoryPressure() (float64, error) {
+	meminfo, err := client.readMeminfo()
+	if err != nil {
+		return 0, err
+	}
+
+	total, available := meminfo["MemTotal"], meminfo["MemAvailable"]
+	if total == 0 {
+		return 0, fmt.Errorf("no total memory found in /proc/meminfo")
+	}
+	if available > total {
+		available = total
+	}
+
+	return float64(total-available) / float64(total), nil
+}
+
+// WaitForLowMemoryPressure blocks until the memory pressure of the remote
+// host drops below the threshold or returns an error once the timeout is
+// exceeded. This prevents installations on hosts that are low on memory.
+func (client *Client) WaitForLowMemoryPressure(threshold float64, timeout time.Duration) error {
+	return client.PollUntil(func(client *Client) (bool, error) {
+		pressure, err := client.GetMemoryPressure()
+		if err != nil {
+			return false, Permanent(err)
+		}
+
+		if pressure >= threshold {
+			client.Logger.Info().Float64("pressure", pressure).Msg("Waiting for memory pressure to drop")
+			return false, fmt.Errorf("memory pressure %.2f not below %.2f", pressure, threshold)
+		}
+
+		return true, nil
+	}, loadPollInterval, timeout)
+}
+
+// DiskIOStats describes the I/O statistics of a block device since boot.
+type DiskIOStats struct {
+	// ReadsCompleted is the number of completed reads.
+	ReadsCompleted uint64
+	// WritesCompleted is the number of completed writes.
+	WritesCompleted uint64
+	// ReadSectors is the number of 512 byte sectors read.
+	ReadSectors uint64
+	// WrittenSectors is the number of 512 byte sectors written.
+	WrittenSectors uint64
+	// IoInProgress is the number of I/O operations currently in progress.
+	IoInProgress uint64
+}
+
+// GetDiskIOStats returns the I/O statistics of a block device, such as
+// "sda" or "/dev/nvme0n1", from "/proc/diskstats". A high number of
+// operations in progress indicates that the device is saturated.
+func (client *Client) GetDiskIOStats(device string) (*DiskIOStats, error) {
+	device = strings.TrimPrefix(device, "/dev/")
+
+	content, err := client.ReadFile("/proc/diskstats")
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		// The columns are: major minor name reads_completed reads_merged
+		// sectors_read time_reading writes_completed writes_merged
+		// sectors_written time_writing ios_in_progress ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 12 || fields[2] != device {
+			continue
+		}
+
+		values := make([]uint64, 12)
+		for _, i := range []int{3, 5, 7, 9, 11} {
+			if values[i], err = strconv.ParseUint(fields[i], 10, 64); err != nil {
+				return nil, err
+			}
+		}
+
+		return &DiskIOStats{
+			ReadsCompleted:  values[3],
+			ReadSectors:     values[5],
+			WritesCompleted: values[7],
+			WrittenSectors:  values[9],
+			IoInProgress:    values[11],
+		}, nil
+	}
+
+	return nil, fmt.Errorf("no disk stats found for device: %s", device)
+}
+
+// prlimitResources are the resources supported by "prlimit".
+var prlimitResources = map[string]bool{
+	"as":         true,
+	"core":       true,
+	"cpu":        true,
+	"data":       true,
+	"fsize":      true,
+	"locks":      true,
+	"memlock":    true,
+	"msgqueue":   true,
+	"nice":       true,
+	"nofile":     true,
+	"nproc":      true,
+	"rss":        true,
+	"rtprio":     true,
+	"rttime":     true,
+	"sigpending": true,
+	"stack":      true,
+}
+
+// SetLimits changes a resource limit of the running k3s process, such as
+// "nofile", without restarting it. A negative limit removes the limit.
+// Please note that the limits are reset once k3s is restarted.
+func (client *Client) SetLimits(resource string, softLimit, hardLimit int64) error {
+	if !prlimitResources[resource] {
+		return fmt.Errorf("unsupported resource: %s", resource)
+	}
+
+	pid, err := client.GetSystemdServicePID(client.k3sService())
+	if err != nil {
+		return err
+	}
+
+	limit := func(value int64) string {
+		if value < 0 {
+			return "unlimited"
+		}
+		return strconv.FormatInt(value, 10)
+	}
+
+	client.Logger.Info().Str("resource", resource).Int("pid", pid).Msg("Setting resource limit")
+	_, err = client.output(Cmd{
+		Cmd: fmt.Sprintf("sudo prlimit --%s=%s:%s --pid=%d", resource, limit(softLimit), limit(hardLimit), pid),
+	})
+
+	return err
+}
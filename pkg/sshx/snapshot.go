@@ -0,0 +1,218 @@
+package sshx
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NodeSnapshot describes the state of a node at a point in time. Taking a
+// snapshot before and after a change provides a record for change management.
+type NodeSnapshot struct {
+	// Time is the time at which the snapshot was taken.
+	Time time.Time
+	// SystemInfo describes the operating system of the node.
+	SystemInfo SystemInfo
+	// K3SVersion is the installed version of k3s, if any.
+	K3SVersion string
+	// ServiceStatuses maps the services of k3s and containerd to their state.
+	ServiceStatuses map[string]string
+	// OpenPorts are the local addresses of the listening TCP sockets.
+	OpenPorts []string
+	// DiskUsage describes the usage of the local file systems.
+	DiskUsage []DiskUsage
+	// MemoryUsage describes the usage of the memory.
+	MemoryUsage MemoryUsage
+	// LoadAverage are the 1, 5 and 15 minute load averages.
+	LoadAverage [3]float64
+	// K3SConfig is the k3s configuration file, if any, with secrets hashed.
+	K3SConfig map[string]interface{}
+}
+
+// SystemInfo describes the operating system of a node.
+type SystemInfo struct {
+	Hostname string
+	Arch     string
+	Kernel   string
+	OS       string
+}
+
+// DiskUsage describes the usage of a file system.
+type DiskUsage struct {
+	MountPoint     string
+	TotalBytes     uint64
+	UsedBytes      uint64
+	AvailableBytes uint64
+}
+
+// MemoryUsage describes the usage of the memory.
+type MemoryUsage struct {
+	TotalBytes     uint64
+	AvailableBytes uint64
+}
+
+// Snapshot collects the current state of the node. The k3s version and
+// configuration are omitted if k3s is not installed or not configured.
+func (client *Client) Snapshot() (*NodeSnapshot, error) {
+	snapshot := &NodeSnapshot{
+		Time:            time.Now().UTC(),
+		ServiceStatuses: make(map[string]string),
+	}
+
+	var err error
+	if snapshot.SystemInfo, err = client.getSystemInfo(); err != nil {
+		return nil, err
+	}
+
+	if snapshot.K3SVersion, err = client.GetK3SVersion(); err != nil && !errors.Is(err, ErrK3SNotInstalled) {
+		return nil, err
+	}
+
+	for _, service := range []string{client.k3sService(), "containerd"} {
+		if snapshot.ServiceStatuses[service], err = client.ServiceStatus(service); err != nil {
+			return nil, err
+		}
+	}
+
+	if snapshot.OpenPorts, err = client.getOpenPorts(); err != nil {
+		return nil, err
+	}
+
+	if snapshot.DiskUsage, err = client.getDiskUsage(); err != nil {
+		return nil, err
+	}
+
+	meminfo, err := client.readMeminfo()
+	if err != nil {
+		return nil, err
+	}
+	snapshot.MemoryUsage = MemoryUsage{
+		TotalBytes:     meminfo["MemTotal"],
+		AvailableBytes: meminfo["MemAvailable"],
+	}
+
+	if snapshot.LoadAverage, err = client.GetLoadAverage(); err != nil {
+		return nil, err
+	}
+
+	config, err := client.readK3SConfigMap()
+	if err != nil {
+		return nil, err
+	}
+	if len(config) > 0 {
+		for key, value := range config {
+			if k3sConfigSecrets[key] {
+				hash := sha256.Sum256([]byte(fmt.Sprint(value)))
+				config[key] = "sha256:" + hex.EncodeToString(hash[:])
+			}
+		}
+		snapshot.K3SConfig = config
+	}
+
+	return snapshot, nil
+}
+
+// getSystemInfo returns the hostname, architecture, kernel
+// release and operating system name of the node.
+func (client *Client) getSystemInfo() (SystemInfo, error) {
+	var info SystemInfo
+	var err error
+
+	if info.Hostname, err = client.GetHostname(); err != nil {
+		return info, err
+	}
+
+	if info.Arch, err = client.GetArch(); err != nil {
+		return info, err
+	}
+
+	if info.Kernel, err = client.output(Cmd{Cmd: "uname -r"}); err != nil {
+		return info, err
+	}
+
+	content, err := client.ReadFile("/etc/os-release")
+	if err != nil {
+		return info, err
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(content)))
+	for scanner.Scan() {
+		if value, found := strings.CutPrefix(scanner.Text(), "PRETTY_NAME="); found {
+			info.OS = strings.Trim(value, `"'`)
+		}
+	}
+
+	return info, nil
+}
+
+// getOpenPorts returns the sorted local addresses
+// of the listening TCP sockets, such as "*:6443".
+func (client *Client) getOpenPorts() ([]string, error) {
+	output, err := client.output(Cmd{
+		Cmd: "ss -ltn",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var ports []string
+
+	// The columns are "State Recv-Q Send-Q Local-Address:Port Peer-Address:Port".
+	for _, line := range strings.Split(output, "\n")[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || seen[fields[3]] {
+			continue
+		}
+
+		seen[fields[3]] = true
+		ports = append(ports, fields[3])
+	}
+
+	sort.Strings(ports)
+
+	return ports, nil
+}
+
+// getDiskUsage returns the usage of the local file systems
+// excluding pseudo file systems, such as "tmpfs".
+func (client *Client) getDiskUsage() ([]DiskUsage, error) {
+	output, err := client.output(Cmd{
+		Cmd: "df -P -l -B1 -x tmpfs -x devtmpfs -x overlay -x squashfs",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var usage []DiskUsage
+
+	// The columns are "Filesystem 1-blocks Used Available Capacity Mounted on".
+	for _, line := range strings.Split(output, "\n")[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			continue
+		}
+
+		var values [3]uint64
+		for i := range values {
+			if values[i], err = strconv.ParseUint(fields[i+1], 10, 64); err != nil {
+				return nil, fmt.Errorf("malformed disk usage: %s", line)
+			}
+		}
+
+		usage = append(usage, DiskUsage{
+			MountPoint:     strings.Join(fields[5:], " "),
+			TotalBytes:     values[0],
+			UsedBytes:      values[1],
+			AvailableBytes: values[2],
+		})
+	}
+
+	return usage, nil
+}
@@ -0,0 +1,270 @@
+package sshx
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// cgroupV2Parameter is the kernel parameter that instructs
+	// systemd to mount the unified cgroup hierarchy.
+	cgroupV2Parameter = "systemd.unified_cgroup_hierarchy=1"
+	// loadPollInterval is the interval at which the load
+	// average is polled while waiting for a host to settle.
+	loadPollInterval = time.Second * 5
+	// maxClockOffset is the maximum tolerated clock offset after a time sync.
+	maxClockOffset = time.Second
+	// ipvsModulesLoadPath is the file that loads the IPVS modules on boot.
+	ipvsModulesLoadPath = "/etc/modules-load.d/ipvs.conf"
+)
+
+var (
+	chronyOffset  = regexp.MustCompile(`System time\s*:\s*([0-9.]+) seconds (slow|fast)`)
+	ntpdateOffset = regexp.MustCompile(`offset ([-+]?[0-9.]+) sec`)
+)
+
+// SetupCgroupV2 enables the unified cgroup hierarchy by adding the
+// corresponding parameter to the kernel command line. As this change
+// only takes effect after a reboot, a reboot is scheduled and the
+// function returns ErrRebootRequired to notify the caller.
+func (client *Client) SetupCgroupV2() error {
+	fsType, err := client.output(Cmd{
+		Cmd: "stat -fc %T /sys/fs/cgroup",
+	})
+	if err != nil {
+		return err
+	}
+
+	// Nothing to do if the host is already using cgroup v2.
+	if fsType == "cgroup2fs" {
+		return nil
+	}
+
+	grub, err := client.output(Cmd{
+		Cmd: "cat /etc/default/grub",
+	})
+	if err != nil {
+		return err
+	}
+
+	// The parameter may have been added by a previous run,
+	// in which case we are only waiting for the reboot.
+	if !strings.Contains(grub, cgroupV2Parameter) {
+		client.Logger.Info().Msg("Enabling cgroup v2 on kernel command line")
+		if err := client.Do(Cmd{
+			Cmd: `sudo sed -i 's/^GRUB_CMDLINE_LINUX="/&` + cgroupV2Parameter + ` /' /etc/default/grub`,
+		}); err != nil {
+			return err
+		}
+
+		// The expression only matches the default format of the
+		// GRUB config, so verify that the parameter was added to
+		// avoid rebooting the host without any effect.
+		grub, err := client.output(Cmd{
+			Cmd: "cat /etc/default/grub",
+		})
+		if err != nil {
+			return err
+		}
+
+		if !strings.Contains(grub, cgroupV2Parameter) {
+			return fmt.Errorf("failed to add %s to /etc/default/grub: no GRUB_CMDLINE_LINUX found", cgroupV2Parameter)
+		}
+
+		if err := client.Do(Cmd{
+			Cmd: "sudo update-grub",
+		}); err != nil {
+			return err
+		}
+	}
+
+	// Schedule the reboot instead of running it immediately
+	// to allow the SSH session to terminate gracefully.
+	client.Logger.Warn().Msg("Scheduling reboot to enable cgroup v2")
+	if err := client.Do(Cmd{
+		Cmd: "sudo shutdown -r +1",
+	}); err != nil {
+		return err
+	}
+
+	return ErrRebootRequired
+}
+
+// GetHostname returns the hostname of the remote host.
+func (client *Client) GetHostname() (string, error) {
+	return client.output(Cmd{
+		Cmd: "hostname",
+	})
+}
+
+// archAliases maps the machine hardware names
+// reported by "uname -m" to GOARCH names.
+var archAliases = map[string]string{
+	"x86_64":  "amd64",
+	"amd64":   "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+	"armv7l":  "arm",
+	"armv6l":  "arm",
+	"armhf":   "arm",
+	"arm":     "arm",
+	"s390x":   "s390x",
+}
+
+// GetArch returns the CPU architecture of the remote
+// host normalized to the corresponding GOARCH name.
+func (client *Client) GetArch() (string, error) {
+	machine, err := client.output(Cmd{
+		Cmd: "uname -m",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	arch, ok := archAliases[machine]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedArch, machine)
+	}
+
+	return arch, nil
+}
+
+// GetLoadAverage returns the 1, 5 and 15 minute load averages of the remote host.
+func (client *Client) GetLoadAverage() ([3]float64, error) {
+	var load [3]float64
+
+	content, err := client.ReadFile("/proc/loadavg")
+	if err != nil {
+		return load, err
+	}
+
+	fields := strings.Fields(string(content))
+	if len(fields) < len(load) {
+		return load, fmt.Errorf("malformed load average: %s", content)
+	}
+
+	for i := range load {
+		if load[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return load, err
+		}
+	}
+
+	return load, nil
+}
+
+// EnsureKernelModule loads a kernel module, such as "br_netfilter", unless
+// it is already loaded. Please note that the module is not loaded on boot.
+func (client *Client) EnsureKernelModule(name string) error {
+	if err := client.Do(Cmd{
+		Cmd: "test -d /sys/module/" + name,
+	}); err == nil {
+		return nil
+	}
+
+	client.Logger.Info().Str("module", name).Msg("Loading kernel module")
+	_, err := client.output(Cmd{
+		Cmd: "sudo modprobe " + name,
+	})
+
+	return err
+}
+
+// ipvsModules are the kernel modules required by kube-proxy in IPVS mode.
+var ipvsModules = []string{"ip_vs", "ip_vs_rr", "ip_vs_wrr", "ip_vs_sh", "nf_conntrack"}
+
+// SetupIPVS loads the kernel modules required to run kube-proxy in IPVS
+// mode via "--kube-proxy-arg=proxy-mode=ipvs" and loads them on boot.
+func (client *Client) SetupIPVS() error {
+	for _, module := range ipvsModules {
+		if err := client.EnsureKernelModule(module); err != nil {
+			return err
+		}
+	}
+
+	content := strings.Join(ipvsModules, "\n") + "\n"
+	return client.AtomicWriteFile(ipvsModulesLoadPath, []byte(content), 0644)
+}
+
+// WaitForLowLoad blocks until the 1 minute load average of the remote
+// host drops below the threshold or returns an error once the timeout
+// is exceeded. This prevents installations on hosts that are busy.
+func (client *Client) WaitForLowLoad(threshold float64, timeout time.Duration) error {
+	return client.PollUntil(func(client *Client) (bool, error) {
+		load, err := client.GetLoadAverage()
+		if err != nil {
+			return false, Permanent(err)
+		}
+
+		if load[0] >= threshold {
+			client.Logger.Info().Float64("load", load[0]).Msg("Waiting for load to drop")
+			return false, fmt.Errorf("load average %.2f not below %.2f", load[0], threshold)
+		}
+
+		return true, nil
+	}, loadPollInterval, timeout)
+}
+
+// SyncTime forces a time synchronization on the remote host using chrony or
+// ntpdate, whichever is available. Please note that chrony uses its own
+// configured sources, while ntpdate queries the specified NTP server.
+// An error is returned if the clock offset remains significant.
+func (client *Client) SyncTime(ntpServer string) error {
+	var offset float64
+
+	if _, err := client.output(Cmd{Cmd: "command -v chronyc"}); err == nil {
+		client.Logger.Info().Msg("Synchronizing time via chrony")
+		if err := client.Do(Cmd{
+			Cmd: "sudo chronyc makestep",
+		}); err != nil {
+			return err
+		}
+
+		tracking, err := client.output(Cmd{Cmd: "chronyc tracking"})
+		if err != nil {
+			return err
+		}
+
+		match := chronyOffset.FindStringSubmatch(tracking)
+		if match == nil {
+			return fmt.Errorf("malformed chrony tracking: %s", tracking)
+		}
+		if offset, err = strconv.ParseFloat(match[1], 64); err != nil {
+			return err
+		}
+	} else if _, err := client.output(Cmd{Cmd: "command -v ntpdate"}); err == nil {
+		client.Logger.Info().Str("server", ntpServer).Msg("Synchronizing time via ntpdate")
+		if err := client.Do(Cmd{
+			Cmd: "sudo ntpdate -u " + ntpServer,
+		}); err != nil {
+			return err
+		}
+
+		query, err := client.output(Cmd{Cmd: "ntpdate -q " + ntpServer})
+		if err != nil {
+			return err
+		}
+
+		matches := ntpdateOffset.FindAllStringSubmatch(query, -1)
+		if matches == nil {
+			return fmt.Errorf("malformed ntpdate query: %s", query)
+		}
+		// The last line contains the offset of the selected server.
+		if offset, err = strconv.ParseFloat(matches[len(matches)-1][1], 64); err != nil {
+			return err
+		}
+	} else {
+		return fmt.Errorf("neither chronyc nor ntpdate available")
+	}
+
+	clockOffset := time.Duration(math.Abs(offset) * float64(time.Second))
+	client.Logger.Info().Dur("offset", clockOffset).Msg("Synchronized time")
+	if clockOffset > maxClockOffset {
+		return fmt.Errorf("clock offset of %s exceeds %s", clockOffset, maxClockOffset)
+	}
+
+	return nil
+}
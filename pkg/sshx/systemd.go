@@ -0,0 +1,206 @@
+package sshx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// SystemdUnitDir is the directory containing the unit files of the administrator.
+	SystemdUnitDir = "/etc/systemd/system"
+)
+
+// ServiceStart starts a systemd service on the remote host.
+func (client *Client) ServiceStart(name string) error {
+	client.Logger.Info().Str("service", name).Msg("Starting service")
+	return client.Do(Cmd{
+		Cmd: "sudo systemctl start " + name,
+	})
+}
+
+// ServiceStop stops a systemd service on the remote host.
+func (client *Client) ServiceStop(name string) error {
+	client.Logger.Info().Str("service", name).Msg("Stopping service")
+	return client.Do(Cmd{
+		Cmd: "sudo systemctl stop " + name,
+	})
+}
+
+// ServiceRestart restarts a systemd service on the remote host.
+func (client *Client) ServiceRestart(name string) error {
+	client.Logger.Info().Str("service", name).Msg("Restarting service")
+	return client.Do(Cmd{
+		Cmd: "sudo systemctl restart " + name,
+	})
+}
+
+// ServiceStatus returns the state of a systemd service on the remote
+// host as reported by "systemctl is-active", such as "active".
+func (client *Client) ServiceStatus(name string) (string, error) {
+	stdout := new(bytes.Buffer)
+	err := client.Do(Cmd{
+		Cmd:    "systemctl is-active " + name,
+		Stdout: stdout,
+	})
+
+	// The command exits with a non-zero code if the service is
+	// not active, but still prints the state of the service.
+	var exitErr *ssh.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return "", err
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// EnableSystemdService enables a systemd service on the remote
+// host, which starts the service automatically after a reboot.
+func (client *Client) EnableSystemdService(unitName string) error {
+	client.Logger.Info().Str("service", unitName).Msg("Enabling service")
+	return client.Do(Cmd{
+		Cmd: "sudo systemctl enable " + unitName,
+	})
+}
+
+// DisableSystemdService disables a systemd service on the remote host,
+// which prevents the service from starting after a reboot.
+func (client *Client) DisableSystemdService(unitName string) error {
+	client.Logger.Info().Str("service", unitName).Msg("Disabling service")
+	return client.Do(Cmd{
+		Cmd: "sudo systemctl disable " + unitName,
+	})
+}
+
+// GetSystemdServicePID returns the PID of the main process of a systemd
+// service, which may be passed to GetK3SProcMetrics for the k3s service.
+func (client *Client) GetSystemdServicePID(serviceName string) (int, error) {
+	output, err := client.output(Cmd{
+		Cmd: "systemctl show " + serviceName + " --property=MainPID",
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// The output has the format "MainPID=<pid>".
+	value, found := strings.CutPrefix(output, "MainPID=")
+	if !found {
+		return 0, fmt.Errorf("malformed systemd output: %s", output)
+	}
+
+	pid, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+
+	// Services that are not running have no main process.
+	if pid == 0 {
+		return 0, fmt.Errorf("service not running: %s", serviceName)
+	}
+
+	return pid, nil
+}
+
+// GetSystemdUnitFile returns the unit file of a systemd unit as reported
+// by "systemctl cat", which includes the content of all drop-in files.
+func (client *Client) GetSystemdUnitFile(unitName string) (string, error) {
+	return client.output(Cmd{
+		Cmd: "systemctl cat " + unitName,
+	})
+}
+
+// WriteSystemdUnitFile writes a unit file to the systemd unit directory and
+// reloads the systemd configuration. The unit is enabled if AutoEnable is
+// set. Please note that the unit is not restarted.
+func (client *Client) WriteSystemdUnitFile(unitName, content string) error {
+	client.Logger.Info().Str("unit", unitName).Msg("Writing unit file")
+	if err := client.AtomicWriteFile(path.Join(SystemdUnitDir, unitName), []byte(content), 0644); err != nil {
+		return err
+	}
+
+	if err := client.daemonReload(); err != nil {
+		return err
+	}
+
+	if client.AutoEnable {
+		return client.EnableSystemdService(unitName)
+	}
+
+	return nil
+}
+
+// daemonReload reloads the configuration of systemd after unit files changed.
+func (client *Client) daemonReload() error {
+	return client.Do(Cmd{
+		Cmd: "sudo systemctl daemon-reload",
+	})
+}
+
+// GetSystemdDropIn returns the content of a drop-in file of a systemd
+// unit, such as "/etc/systemd/system/k3s.service.d/<name>.conf".
+func (client *Client) GetSystemdDropIn(unitName, dropInName string) (string, error) {
+	content, err := client.ReadFile(systemdDropInPath(unitName, dropInName))
+	if err != nil {
+		return "", err
+	}
+
+	return string(content), nil
+}
+
+// WriteSystemdDropIn writes a drop-in file of a systemd unit, which allows
+// to extend a unit without replacing its unit file, and reloads the
+// systemd configuration. Please note that the unit is not restarted.
+func (client *Client) WriteSystemdDropIn(unitName, dropInName, content string) error {
+	client.Logger.Info().Str("unit", unitName).Str("drop-in", dropInName).Msg("Writing drop-in file")
+	if err := client.AtomicWriteFile(systemdDropInPath(unitName, dropInName), []byte(content), 0644); err != nil {
+		return err
+	}
+
+	return client.daemonReload()
+}
+
+// systemdDropInPath returns the location of a drop-in file of a systemd unit.
+func systemdDropInPath(unitName, dropInName string) string {
+	return path.Join(SystemdUnitDir, unitName+".d", dropInName+".conf")
+}
+
+// RunOneShot runs a command as a transient systemd unit via "systemd-run",
+// which tracks its resources and cleans up all of its processes. The unit
+// keeps running if the SSH session drops, but is stopped by systemd once
+// the timeout expires. The command is run by "sh" within the unit, so
+// commands chained via "&&" or pipes are confined as well. The output
+// of the command is logged.
+func (client *Client) RunOneShot(cmd string, timeout time.Duration) error {
+	// The session is given a grace period to receive the exit status
+	// of the unit after systemd enforced the timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+k3sAttemptTimeout)
+	defer cancel()
+
+	// The "--pipe" flag is used instead of "--pty" as the SSH session
+	// does not allocate a terminal. The streams are copied concurrently
+	// and therefore need separate writers.
+	stderr := new(bytes.Buffer)
+	if err := client.DoContext(ctx, Cmd{
+		Cmd: fmt.Sprintf(
+			"sudo systemd-run --wait --pipe --collect --quiet --property=RuntimeMaxSec=%d -- sh -c %s",
+			int(timeout.Seconds()), shellQuote(cmd),
+		),
+		Stdout: &logWriter{logger: client.Logger},
+		Stderr: io.MultiWriter(&logWriter{logger: client.Logger}, stderr),
+	}); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package sshx
+
+import (
+	"path"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TraefikConfig customizes the Traefik ingress controller bundled with k3s.
+type TraefikConfig struct {
+	// SSLRedirect redirects all HTTP traffic to HTTPS.
+	SSLRedirect bool
+	// EntryPoints are additional entry points by name, such as
+	// "metrics", and the port they are exposed on.
+	EntryPoints map[string]int
+	// AdditionalArguments are passed to Traefik as is, such
+	// as "--log.level=DEBUG".
+	AdditionalArguments []string
+}
+
+// helmChartConfig is a custom resource of the helm controller embedded in
+// k3s, which overrides the values of a chart that is bundled with k3s.
+type helmChartConfig struct {
+	APIVersion string              `yaml:"apiVersion"`
+	Kind       string              `yaml:"kind"`
+	Metadata   manifestMetadata    `yaml:"metadata"`
+	Spec       helmChartConfigSpec `yaml:"spec"`
+}
+
+// helmChartConfigSpec contains the values that override the chart defaults.
+type helmChartConfigSpec struct {
+	ValuesContent string `yaml:"valuesContent"`
+}
+
+// InstallTraefikConfig overrides the values of the Traefik chart that is
+// installed by k3s by writing a HelmChartConfig resource to the manifests
+// directory. The file is touched afterwards to trigger a reconciliation.
+func (client *Client) InstallTraefikConfig(config TraefikConfig) error {
+	values := make(map[string]interface{})
+
+	ports := make(map[string]interface{})
+	if config.SSLRedirect {
+		ports["web"] = map[string]interface{}{
+			"redirectTo": map[string]interface{}{
+				"port": "websecure",
+			},
+		}
+	}
+	for name, port := range config.EntryPoints {
+		ports[name] = map[string]interface{}{
+			"port":        port,
+			"exposedPort": port,
+			"protocol":    "TCP",
+			"expose": map[string]interface{}{
+				"default": true,
+			},
+		}
+	}
+	if len(ports) > 0 {
+		values["ports"] = ports
+	}
+	if len(config.AdditionalArguments) > 0 {
+		values["additionalArguments"] = config.AdditionalArguments
+	}
+
+	valuesContent, err := yaml.Marshal(values)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := yaml.Marshal(&helmChartConfig{
+		APIVersion: "helm.cattle.io/v1",
+		Kind:       "HelmChartConfig",
+		Metadata: manifestMetadata{
+			Name:      "traefik",
+			Namespace: "kube-system",
+		},
+		Spec: helmChartConfigSpec{
+			ValuesContent: string(valuesContent),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	manifestPath := path.Join(K3SManifestsDir, "traefik-config.yaml")
+
+	client.Logger.Info().Msg("Configuring Traefik")
+	if err := client.AtomicWriteFile(manifestPath, manifest, 0600); err != nil {
+		return err
+	}
+
+	return client.Do(Cmd{
+		Cmd: "sudo touch " + manifestPath,
+	})
+}
@@ -0,0 +1,158 @@
+package sshx
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// k3sUpgradeDiskSpace is the free disk space required to download a new
+	// k3s binary while keeping the current one, which is about 70 MiB.
+	k3sUpgradeDiskSpace = 256 * 1024 * 1024
+)
+
+// k3sVersionPattern matches k3s versions, such as "v1.31.2+k3s1".
+var k3sVersionPattern = regexp.MustCompile(`^v?(\d+)\.(\d+)\.(\d+)(?:\+k3s(\d+))?$`)
+
+// UpgradeReport describes whether the node can be upgraded.
+type UpgradeReport struct {
+	// CurrentVersion is the installed version of k3s.
+	CurrentVersion string
+	// TargetVersion is the version to upgrade to.
+	TargetVersion string
+	// Eligible reports whether all checks passed.
+	Eligible bool
+	// Reasons describes the checks that failed.
+	Reasons []string
+}
+
+// CheckK3SUpgradeEligibility checks whether k3s can be upgraded to the
+// target version, such as "v1.31.2+k3s1". The target version must be
+// newer and may not skip a minor version, the node must have enough
+// free disk space for the new binary and the etcd quorum must be kept
+// while the node is restarted.
+func (client *Client) CheckK3SUpgradeEligibility(targetVersion string) (*UpgradeReport, error) {
+	currentVersion, err := client.GetK3SVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &UpgradeReport{
+		CurrentVersion: currentVersion,
+		TargetVersion:  targetVersion,
+	}
+
+	current, err := parseK3SVersion(currentVersion)
+	if err != nil {
+		return nil, err
+	}
+	target, err := parseK3SVersion(targetVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	if compareK3SVersions(target, current) <= 0 {
+		report.Reasons = append(report.Reasons, fmt.Sprintf("target version %s is not newer than %s", targetVersion, currentVersion))
+	} else if target[0] != current[0] || target[1] > current[1]+1 {
+		report.Reasons = append(report.Reasons, fmt.Sprintf("upgrade from %s to %s skips a minor version", currentVersion, targetVersion))
+	}
+
+	available, err := client.output(Cmd{
+		Cmd: "df -Pk " + path.Dir(K3SBinaryPath) + " | tail -n 1 | awk '{print $4}'",
+	})
+	if err != nil {
+		return nil, err
+	}
+	availableKiB, err := strconv.ParseUint(available, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed disk space: %s", available)
+	}
+	if availableKiB*1024 < k3sUpgradeDiskSpace {
+		report.Reasons = append(report.Reasons, fmt.Sprintf("insufficient disk space: %d MiB available", availableKiB/1024))
+	}
+
+	if err := client.ensureEtcdNode(); err == nil {
+		reason, err := client.checkEtcdQuorum()
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			report.Reasons = append(report.Reasons, reason)
+		}
+	}
+
+	report.Eligible = len(report.Reasons) == 0
+
+	return report, nil
+}
+
+// checkEtcdQuorum returns a reason if the etcd cluster would lose
+// its quorum while a member is restarted or if a member is unhealthy.
+func (client *Client) checkEtcdQuorum() (string, error) {
+	output, err := client.etcdctl("member list -w json")
+	if err != nil {
+		return "", err
+	}
+
+	members := new(etcdMemberList)
+	if err := json.Unmarshal([]byte(output), members); err != nil {
+		return "", err
+	}
+
+	// A single member cluster is unavailable during the upgrade
+	// anyway, which is why only multi-member clusters are checked.
+	total := len(members.Members)
+	if total > 1 && total-1 < total/2+1 {
+		return fmt.Sprintf("etcd quorum would be lost with %d members", total), nil
+	}
+
+	if _, err := client.etcdctl("endpoint health --cluster"); err != nil {
+		return fmt.Sprintf("etcd cluster is unhealthy: %v", err), nil
+	}
+
+	return "", nil
+}
+
+// parseK3SVersion parses a k3s version into its major, minor,
+// patch and k3s revision. A missing revision is treated as 0.
+func parseK3SVersion(version string) ([4]int, error) {
+	var parsed [4]int
+
+	matches := k3sVersionPattern.FindStringSubmatch(strings.TrimSpace(version))
+	if matches == nil {
+		return parsed, fmt.Errorf("malformed k3s version: %s", version)
+	}
+
+	for i, match := range matches[1:] {
+		if match == "" {
+			continue
+		}
+
+		value, err := strconv.Atoi(match)
+		if err != nil {
+			return parsed, err
+		}
+		parsed[i] = value
+	}
+
+	return parsed, nil
+}
+
+// compareK3SVersions returns -1, 0 or 1 if a is older,
+// equal to or newer than b.
+func compareK3SVersions(a, b [4]int) int {
+	for i := range a {
+		if a[i] < b[i] {
+			return -1
+		}
+		if a[i] > b[i] {
+			return 1
+		}
+	}
+
+	return 0
+}